@@ -1,16 +1,76 @@
 package bmc
 
 import (
+	"math"
+	"math/rand"
+	"net/rpc"
+	"sync"
 	"time"
 
 	ad "github.com/pbenner/autodiff"
 	ads "github.com/pbenner/autodiff/simple"
 )
 
-// Sample is a structure that receives sampled value
+// Sample is a structure that receives sampled value.
+// Samples arrive ordered by iteration and then ID, so draw k of particle i
+// is the (k*NumParticles+i)-th sample of the stream.
 type Sample struct {
 	ID int
-	X  []float64
+	// Iteration counts the draws of the particle before this one
+	Iteration int
+	X         []float64
+	// Warmup is set during the first NumWarmup or MaxAdapt iterations, whichever is longer
+	Warmup bool
+	// PotentialEnergy is minus the log density at X, with the log Jacobian of the Transform if any.
+	// For SGHMC with a Minibatch it is estimated on a random batch, like the energies and the radius,
+	// so that a draw costs no pass over the full dataset.
+	PotentialEnergy float64
+	// KineticEnergy is the kinetic energy at the end of the transition, before the collision
+	KineticEnergy float64
+	Accepted      bool
+	Acceptance    float64
+	StepSize      float64
+	// TreeDepth is the depth of the trajectory tree of NUTS, 0 for other samplers
+	TreeDepth int
+	// Collided is set if the particle collided after the transition
+	Collided bool
+	// Radius is the radius of the particle after the transition
+	Radius float64
+	// P is the momentum at the end of the transition, nil unless KeepMomentum is set
+	P []float64
+	// Divergent is set if the transition that led to X diverged
+	Divergent bool
+	// DivergenceX is the constrained position where the trajectory diverged, nil otherwise
+	DivergenceX []float64
+	// Generated holds the GeneratedQuantities of X, nil during warmup or without them
+	Generated []float64
+}
+
+// GeneratedQuantities derives quantities from a constrained draw, such as predictions
+// or transformed parameters. Random quantities are drawn from rng, the generator of the
+// particle, which is not shared with other particles. The draw x must not be modified.
+type GeneratedQuantities func(x []float64, rng *rand.Rand) []float64
+
+// Energy holds the Hamiltonian of a particle around one iteration
+type Energy struct {
+	// Start is the Hamiltonian before the transition, after the previous collision
+	Start float64
+	// BeforeCollision is the Hamiltonian after the transition
+	BeforeCollision float64
+	// AfterCollision is the Hamiltonian after the collision, the start of the next transition
+	AfterCollision float64
+	// Collided is set if the particle collided, otherwise the collision resampled its momentum
+	Collided bool
+}
+
+// Frame is the state of all particles after the collisions of one iteration.
+// Positions are in the space the sampler moves in, unconstrained if there is a Transform.
+type Frame struct {
+	Iteration int
+	X, P      [][]float64
+	Radius    []float64
+	// Collided lists the particles that collided in this iteration
+	Collided []int
 }
 
 // BrownianMonteCarlo simulates collisions of particles.
@@ -21,18 +81,66 @@ type BrownianMonteCarlo struct {
 	NumParticles int
 	Radius       []float64
 	Masses       []ad.Scalar
+	// Transform maps unconstrained to constrained parameters, nil means unconstrained
+	Transform Transform
+	// Names label the constrained parameters, such as beta[1] or sigma (see ExpandNames),
+	// nil leaves them anonymous
+	Names []string
+	// LogDensity means the target returns a log density instead of a density,
+	// for posteriors whose density underflows
+	LogDensity bool
+	// MaxDivergences is the number of consecutive divergent transitions
+	// of a particle after which sampling fails (default 100)
+	MaxDivergences int
+	// Record keeps a Frame of every iteration in Frames
+	Record bool
+	// KeepMomentum sends the momentum of every draw in Sample.P
+	KeepMomentum bool
+	// GeneratedQuantities runs on every draw after warmup and fills Sample.Generated
+	GeneratedQuantities GeneratedQuantities
+	// GeneratedNames label the generated quantities, nil leaves them anonymous
+	GeneratedNames []string
+	// Workers are the addresses of worker processes that run the transitions of the particles,
+	// particle i on worker i mod len(Workers). Empty means all particles run in this process.
+	// See ServeWorker.
+	Workers []string
+	// Asynchronous lets every particle step without waiting for the others. A particle collides
+	// against the latest known positions and momenta of its neighbours after each of its
+	// transitions, and a Frame is recorded after every transition. The default synchronous
+	// mode collides all particles together after each iteration.
+	Asynchronous bool
+	// NumWarmup is the number of warmup iterations of every particle, whose draws are marked
+	// Sample.Warmup and get no generated quantities. Warmup lasts at least MaxAdapt iterations,
+	// so 0 marks only the draws during step size adaptation.
+	NumWarmup int
+	// Window is the number of draws of a particle that may wait for the draws of slower particles
+	// in asynchronous mode (default 10). A particle that is this far ahead waits for the others.
+	Window int
 
 	// Statistics
 	NumCollisions []int
 	NumAccepted   []int
 	NumRejected   []int
+	// NumEvaluations counts evaluations of the potential energy (and its gradient) per particle
+	NumEvaluations []int
+	// NumDivergences counts divergent transitions per particle
+	NumDivergences []int
+	// Energies logs the Hamiltonian of every particle in every iteration, indexed by [particle][iteration]
+	Energies [][]Energy
+	Frames   []Frame
 
 	// Private attributes
 	sample          chan Sample
 	collidedSample  chan Sample
 	coefficients    [][]map[string]ad.Scalar
-	stop            bool
+	done            chan struct{}
+	stopOnce        *sync.Once
+	err             error
 	potentialEnergy logDistribution
+	samplerEnergy   logDistribution
+	particleEnergy  []logDistribution
+	workers         []*rpc.Client
+	rngs            []*rand.Rand
 
 	// For plotting
 	InitialRadius float64
@@ -44,19 +152,45 @@ type BrownianMonteCarlo struct {
 	Delta          ad.Scalar
 }
 
-// Sample samples a vector from target distribution(dist) and put it in a channel(sample)
+// Sample samples a vector from target distribution(dist) and put it in a channel(sample).
+// With a Transform, initialX is constrained, sampling runs in the unconstrained space
+// and draws are constrained again before they are sent.
+//
+// Sample returns an error if sampling cannot start. If sampling fails later,
+// the sample channel is closed early and Err reports why.
 func (bmc *BrownianMonteCarlo) Sample(
 	dist distribution,
 	initialX ad.Vector,
 	sample, collidedSample chan Sample,
-) {
+) error {
+	if err := bmc.validate(dist, initialX); err != nil {
+		return err
+	}
+
 	// Initialize
 	bmc.sample = sample
 	bmc.collidedSample = collidedSample
-	bmc.potentialEnergy = minusLogDist(dist)
+	bmc.done = make(chan struct{})
+	bmc.stopOnce = &sync.Once{}
+	bmc.potentialEnergy = potentialEnergyOf(dist, bmc.LogDensity, bmc.Transform)
+	if bmc.Transform != nil {
+		initialX = ad.NewVector(ad.RealType, bmc.Transform.Unconstrain(initialX.GetValues()))
+	}
 	bmc.NumAccepted = make([]int, bmc.NumParticles)
 	bmc.NumRejected = make([]int, bmc.NumParticles)
 	bmc.NumCollisions = make([]int, bmc.NumParticles)
+	bmc.NumEvaluations = make([]int, bmc.NumParticles)
+	bmc.NumDivergences = make([]int, bmc.NumParticles)
+	bmc.Energies = make([][]Energy, bmc.NumParticles)
+	bmc.Frames = nil
+	bmc.particleEnergy = make([]logDistribution, bmc.NumParticles)
+	bmc.rngs = make([]*rand.Rand, bmc.NumParticles)
+	bmc.samplerEnergy = samplerEnergyOf(dist, *bmc)
+	for i := 0; i != bmc.NumParticles; i++ {
+		bmc.particleEnergy[i] = bmc.countEvaluations(i, bmc.samplerEnergy)
+		// seeded from the global source, so that rand.Seed makes runs reproducible
+		bmc.rngs[i] = rand.New(rand.NewSource(rand.Int63()))
+	}
 	// bmc.coefficients = calculateCollisionCoefficients(bmc.Masses)  // legacy
 
 	// Adaptive radius
@@ -76,9 +210,16 @@ func (bmc *BrownianMonteCarlo) Sample(
 		Xs[i] = clone(initialX)
 		// initial P
 		convMatrix := ads.MmulS(ad.IdentityMatrix(ad.RealType, initialX.Dim()), bmc.Masses[i])
-		Ps[i] = sampleZeroMeanNormal(initialX.Dim(), convMatrix)
+		P, err := sampleZeroMeanNormal(initialX.Dim(), convMatrix)
+		if err != nil {
+			return err
+		}
+		Ps[i] = P
 		// current potential energies
 		potentials[i] = bmc.potentialEnergy(Xs[i])
+		if !isFinite(potentials[i].GetValue()) {
+			return &InitError{X: bmc.constrain(initialX), Reason: "log density is not finite"}
+		}
 		// find max potential
 		if potentials[i].GetValue() > maxPotential.GetValue() {
 			maxPotential = potentials[i]
@@ -86,7 +227,10 @@ func (bmc *BrownianMonteCarlo) Sample(
 		// adaptive step size
 		var eps ad.Scalar
 		if bmc.MaxAdapt != 0 {
-			eps = bmc.findReasonableEpsilon(initialX)
+			eps, err = bmc.findReasonableEpsilon(initialX)
+			if err != nil {
+				return err
+			}
 		} else {
 			eps = ad.NewScalar(ad.RealType, 0)
 		}
@@ -108,105 +252,500 @@ func (bmc *BrownianMonteCarlo) Sample(
 	if bmc.Delta == nil {
 		bmc.Delta = ad.NewScalar(ad.RealType, 0.5)
 	}
+	if bmc.MaxDivergences == 0 {
+		bmc.MaxDivergences = 100
+	}
+	if bmc.Window == 0 {
+		bmc.Window = 10
+	}
+	state := &particles{
+		Xs:          Xs,
+		Ps:          Ps,
+		potentials:  potentials,
+		divergences: make([]int, bmc.NumParticles),
+		S:           S,
+		adaptation:  adaptation{mu: mu, gamma: gamma, t0: t0, kappa: kappa},
+	}
+
+	if err := bmc.dialWorkers(); err != nil {
+		return err
+	}
 
 	// Sampling (parallelized)
-	go func() {
-		defer close(sample)
-		// defer close(collidedSample)
-		for {
-			if bmc.stop {
-				break
-			}
-			bmc.count++
-			// fmt.Println("Sample", bmc.count)
-			done := make(chan bool, bmc.NumParticles)
-			for i := 0; i != bmc.NumParticles; i++ {
-				go func(id int) {
-					x, p, accepted, acceptance := bmc.Sampler.Sample(
-						Xs[id], Ps[id], bmc.Masses[id], bmc.potentialEnergy, bmc.dualAvgVarList[id]["eps"],
-					)
-					if accepted {
-						bmc.NumAccepted[id]++
-					} else {
-						bmc.NumRejected[id]++
-					}
-					Xs[id], Ps[id] = x, p
-
-					// adaptive radius
-					newPotential := bmc.potentialEnergy(Xs[id])
-					bmc.Radius[id] = updateRadius(bmc.Radius[id], newPotential, potentials[id], S, Xs[id].Dim())
-					potentials[id] = newPotential
-
-					// adaptive step size
-					bmc.dualAvgVarList[id]["acceptance"] = acceptance
-
-					sample <- Sample{ID: id, X: x.GetValues()}
+	if bmc.Asynchronous {
+		go bmc.sampleAsynchronously(state)
+	} else {
+		go bmc.sampleSynchronously(state)
+	}
+	return nil
+}
+
+// particles is the state of all particles while sampling
+type particles struct {
+	Xs, Ps      []ad.Vector
+	potentials  []ad.Scalar
+	divergences []int
+	// S is the constant of the adaptive radius
+	S          ad.Scalar
+	adaptation adaptation
+	// mutex guards Xs, Ps and the radii in asynchronous mode
+	mutex sync.Mutex
+}
+
+// adaptation holds the constants of dual averaging
+type adaptation struct {
+	mu, gamma, t0, kappa ad.Scalar
+}
+
+// step is the outcome of a transition of one particle
+type step struct {
+	x, p      ad.Vector
+	potential ad.Scalar
+	radius    float64
+	sample    Sample
+}
+
+// transition moves particle id from x and p with the sampler to its draw of the given iteration,
+// and logs its energies and statistics.
+// Only the entries of particle id are written, so particles may step concurrently.
+func (bmc *BrownianMonteCarlo) transition(state *particles, id, iteration int, x, p ad.Vector, potential ad.Scalar, radius float64) (step, error) {
+	start := ads.Add(potential, kineticEnergy(p, bmc.Masses[id])).GetValue()
+	stepSize := bmc.dualAvgVarList[id]["eps"].GetValue()
+	if stepSize == 0 {
+		stepSize = stepSizeOf(bmc.Sampler)
+	}
+	x, p, transition, newPotential, err := bmc.move(id, x, p)
+	if err != nil {
+		return step{}, err
+	}
+	acceptance := transition.Acceptance
+	if transition.Accepted {
+		bmc.NumAccepted[id]++
+	} else {
+		bmc.NumRejected[id]++
+	}
+
+	// non-finite energies count as divergences
+	if math.IsNaN(acceptance.GetValue()) || !isFinite(newPotential.GetValue()) {
+		transition.Divergent = true
+		acceptance = ad.NewScalar(ad.RealType, 0)
+	} else {
+		// adaptive radius
+		radius = updateRadius(radius, newPotential, potential, state.S, x.Dim())
+		potential = newPotential
+	}
+	kinetic := kineticEnergy(p, bmc.Masses[id]).GetValue()
+	bmc.Energies[id] = append(bmc.Energies[id], Energy{
+		Start:           start,
+		BeforeCollision: newPotential.GetValue() + kinetic,
+	})
+	s := Sample{
+		ID:              id,
+		Iteration:       iteration,
+		X:               bmc.constrain(x),
+		Warmup:          iteration < bmc.NumWarmup || iteration < bmc.MaxAdapt,
+		PotentialEnergy: newPotential.GetValue(),
+		KineticEnergy:   kinetic,
+		Accepted:        transition.Accepted,
+		Acceptance:      acceptance.GetValue(),
+		StepSize:        stepSize,
+		TreeDepth:       transition.TreeDepth,
+		Radius:          radius,
+	}
+	if bmc.KeepMomentum {
+		s.P = p.GetValues()
+	}
+	if bmc.GeneratedQuantities != nil && !s.Warmup {
+		s.Generated = bmc.GeneratedQuantities(s.X, bmc.rngs[id])
+		if bmc.GeneratedNames != nil && len(s.Generated) != len(bmc.GeneratedNames) {
+			return step{}, configErrorf("%d generated quantities for %d names", len(s.Generated), len(bmc.GeneratedNames))
+		}
+	}
+	if transition.Divergent {
+		bmc.NumDivergences[id]++
+		state.divergences[id]++
+		s.Divergent = true
+		if transition.DivergenceX != nil {
+			s.DivergenceX = bmc.constrain(transition.DivergenceX)
+		}
+	} else {
+		state.divergences[id] = 0
+	}
+
+	// adaptive step size
+	bmc.dualAvgVarList[id]["acceptance"] = acceptance
+	return step{x: x, p: p, potential: potential, radius: radius, sample: s}, nil
+}
+
+// move runs the sampler on particle id, on its worker if sampling is distributed,
+// and returns the potential energy at the new position on which the sampler moves
+func (bmc *BrownianMonteCarlo) move(id int, x, p ad.Vector) (ad.Vector, ad.Vector, Transition, ad.Scalar, error) {
+	if len(bmc.workers) != 0 {
+		return bmc.moveRemotely(id, x, p)
+	}
+	x, p, transition := bmc.Sampler.Sample(
+		x, p, bmc.Masses[id], bmc.particleEnergy[id], bmc.dualAvgVarList[id]["eps"],
+	)
+	return x, p, transition, bmc.samplerEnergy(x), nil
+}
+
+// fail ends sampling with err unless it already failed
+func (bmc *BrownianMonteCarlo) fail(state *particles, err error) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if bmc.err == nil {
+		bmc.err = err
+	}
+	bmc.halt()
+}
+
+// halt closes done unless sampling already stopped
+func (bmc *BrownianMonteCarlo) halt() {
+	bmc.stopOnce.Do(func() { close(bmc.done) })
+}
+
+// stopped reports whether sampling stopped
+func (bmc *BrownianMonteCarlo) stopped() bool {
+	select {
+	case <-bmc.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// divergenceError reports particle id if it diverged too often in a row
+func (bmc *BrownianMonteCarlo) divergenceError(state *particles, id, iteration int) error {
+	if state.divergences[id] < bmc.MaxDivergences {
+		return nil
+	}
+	return &DivergenceError{
+		Particle:       id,
+		Iteration:      iteration,
+		NumDivergences: state.divergences[id],
+		X:              bmc.constrain(state.Xs[id]),
+	}
+}
+
+// sampleSynchronously steps all particles on a pool of one worker per particle
+// and collides them once all transitions of an iteration are done
+func (bmc *BrownianMonteCarlo) sampleSynchronously(state *particles) {
+	defer close(bmc.sample)
+	defer bmc.closeWorkers()
+	// defer close(collidedSample)
+	start := make([]chan bool, bmc.NumParticles)
+	done := make(chan bool, bmc.NumParticles)
+	// draws of the current iteration, sent in the order of the particles once all are done
+	samples := make([]Sample, bmc.NumParticles)
+	sampled := make([]bool, bmc.NumParticles)
+	for i := range start {
+		start[i] = make(chan bool)
+		go func(id int) {
+			for range start[id] {
+				next, err := bmc.transition(state, id, bmc.count-1, state.Xs[id], state.Ps[id], state.potentials[id], bmc.Radius[id])
+				if err != nil {
+					bmc.fail(state, err)
+					sampled[id] = false
 					done <- true
-				}(i)
+					continue
+				}
+				state.Xs[id], state.Ps[id], state.potentials[id], bmc.Radius[id] = next.x, next.p, next.potential, next.radius
+				samples[id], sampled[id] = next.sample, true
+				done <- true
 			}
-			for i := 0; i != bmc.NumParticles; i++ {
-				<-done
+		}(i)
+	}
+	defer func() {
+		for _, c := range start {
+			close(c)
+		}
+	}()
+	for {
+		if bmc.stopped() {
+			break
+		}
+		bmc.count++
+		for _, c := range start {
+			c <- true
+		}
+		for range start {
+			<-done
+		}
+		for i := 0; i != bmc.NumParticles; i++ {
+			if err := bmc.divergenceError(state, i, bmc.count); err != nil && bmc.err == nil {
+				bmc.err = err
 			}
-			// for i := 0; i != bmc.NumParticles; i++ {
-			// 	HBeforeCollision := hamiltonian(Xs[i], Ps[i], bmc.Masses[i], bmc.potentialEnergy)
-			// 	fmt.Print("[", i+1, "]", HBeforeCollision, ", ")
-			// }
-			// fmt.Print("\n")
-			Ps, _, bmc.NumCollisions = bmc.Collide(Xs, Ps, bmc.Radius, bmc.Masses, bmc.NumCollisions)
-			// for i := 0; i != bmc.NumParticles; i++ {
-			// 	HAfterCollision := hamiltonian(Xs[i], Ps[i], bmc.Masses[i], bmc.potentialEnergy)
-			// 	fmt.Print("[", i+1, "]", HAfterCollision, ", ")
-			// }
-			// fmt.Print("\n")
-
-			// Adaptive step size
-			bmc.dualAveraging(mu, gamma, t0, kappa, bmc.Delta)
-			// fmt.Println(bmc.dualAvgVarList)
 		}
+		var collided []Sample
+		if _, ok := bmc.Sampler.(MomentumFree); !ok && bmc.err == nil {
+			state.Ps, collided, bmc.NumCollisions = bmc.Collide(state.Xs, state.Ps, bmc.Radius, bmc.Masses, bmc.NumCollisions)
+		}
+		for _, c := range collided {
+			samples[c.ID].Collided = true
+		}
+		for i := 0; i != bmc.NumParticles; i++ {
+			if sampled[i] {
+				bmc.sample <- samples[i]
+			}
+		}
+		if bmc.err != nil {
+			break
+		}
+		if bmc.Record {
+			bmc.record(state.Xs, state.Ps, collided)
+		}
+		for i := 0; i != bmc.NumParticles; i++ {
+			energy := &bmc.Energies[i][len(bmc.Energies[i])-1]
+			energy.AfterCollision = ads.Add(state.potentials[i], kineticEnergy(state.Ps[i], bmc.Masses[i])).GetValue()
+			energy.Collided = sampled[i] && samples[i].Collided
+		}
+
+		// Adaptive step size
+		bmc.dualAveraging(state.adaptation, bmc.count, bmc.Delta)
+		// fmt.Println(bmc.dualAvgVarList)
+	}
+}
+
+// sampleAsynchronously steps every particle on its own worker without waiting for the others.
+// After each transition a particle collides against the latest known positions and momenta
+// of its neighbours, and only its own momentum changes.
+func (bmc *BrownianMonteCarlo) sampleAsynchronously(state *particles) {
+	defer close(bmc.sample)
+	defer bmc.closeWorkers()
+	unordered := make(chan Sample, bmc.NumParticles)
+	// a particle takes a place in its window for every draw, which orderSamples frees
+	// when it forwards the draw
+	windows := make([]chan struct{}, bmc.NumParticles)
+	for i := range windows {
+		windows[i] = make(chan struct{}, bmc.Window)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i != bmc.NumParticles; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			state.mutex.Lock()
+			x, p, radius := state.Xs[id], state.Ps[id], bmc.Radius[id]
+			state.mutex.Unlock()
+			potential := state.potentials[id]
+			for iteration := 1; ; iteration++ {
+				if !bmc.waitForWindow(windows[id]) {
+					return
+				}
+				next, err := bmc.transition(state, id, iteration-1, x, p, potential, radius)
+				if err != nil {
+					bmc.fail(state, err)
+					return
+				}
+				x, p, potential, radius = next.x, next.p, next.potential, next.radius
+
+				state.mutex.Lock()
+				state.Xs[id], state.Ps[id], bmc.Radius[id] = x, p, radius
+				if err := bmc.divergenceError(state, id, iteration); err != nil {
+					state.mutex.Unlock()
+					bmc.fail(state, err)
+					unordered <- next.sample
+					return
+				}
+				var collided []Sample
+				if _, ok := bmc.Sampler.(MomentumFree); !ok {
+					p, collided = bmc.collideWithNeighbours(state, id)
+					state.Ps[id] = p
+				}
+				bmc.count++
+				if bmc.Record {
+					bmc.record(state.Xs, state.Ps, collided)
+				}
+				state.mutex.Unlock()
+				next.sample.Collided = len(collided) != 0
+				unordered <- next.sample
+				energy := &bmc.Energies[id][len(bmc.Energies[id])-1]
+				energy.AfterCollision = ads.Add(potential, kineticEnergy(p, bmc.Masses[id])).GetValue()
+				energy.Collided = next.sample.Collided
+
+				// Adaptive step size
+				bmc.adaptStepSize(id, state.adaptation, iteration, bmc.Delta)
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(unordered)
 	}()
+	bmc.orderSamples(unordered, windows)
 }
 
-func (bmc *BrownianMonteCarlo) dualAveraging(mu, gamma, t0, kappa, delta ad.Scalar) {
-	if bmc.count <= bmc.MaxAdapt {
-		m := ad.NewScalar(ad.RealType, float64(bmc.count))
-		temp := ads.Div(ad.NewReal(1), ads.Add(m, t0))
-		for i := 0; i != bmc.NumParticles; i++ {
-			HBar := bmc.dualAvgVarList[i]["Hbar"]
-			acceptance := bmc.dualAvgVarList[i]["acceptance"]
-			epsBar := bmc.dualAvgVarList[i]["epsBar"]
-			HBar = ads.Add(ads.Mul(HBar, ads.Sub(ad.NewReal(1), temp)), ads.Mul(temp, ads.Sub(delta, acceptance)))
-			logEps := ads.Sub(mu, ads.Mul(HBar, ads.Div(ads.Sqrt(m), gamma)))
-			logEpsBar := ads.Add(ads.Mul(logEps, ads.Pow(m, ads.Neg(kappa))), ads.Mul(ads.Log(epsBar), ads.Sub(ad.NewReal(1), ads.Pow(m, ads.Neg(kappa)))))
-			bmc.dualAvgVarList[i]["HBar"] = HBar
-			bmc.dualAvgVarList[i]["epsBar"] = ads.Exp(logEpsBar)
-			bmc.dualAvgVarList[i]["eps"] = ads.Exp(logEps)
+// waitForWindow takes a place in the window of a particle and returns false if sampling stopped first
+func (bmc *BrownianMonteCarlo) waitForWindow(window chan struct{}) bool {
+	select {
+	case window <- struct{}{}:
+		return true
+	case <-bmc.done:
+		return false
+	}
+}
+
+// orderSamples forwards the draws of unordered to the sample channel by iteration and then ID.
+// Draws of particles that are ahead wait until the slower particles caught up, and free their
+// place in the window of their particle when they are forwarded.
+func (bmc *BrownianMonteCarlo) orderSamples(unordered <-chan Sample, windows []chan struct{}) {
+	pending := make(map[int]Sample)
+	next := 0
+	for s := range unordered {
+		pending[s.Iteration*bmc.NumParticles+s.ID] = s
+		for {
+			s, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			bmc.sample <- s
+			<-windows[s.ID]
+			next++
 		}
 	}
 }
 
-func (bmc *BrownianMonteCarlo) findReasonableEpsilon(x ad.Vector) (eps ad.Scalar) {
+// collideWithNeighbours collides particle id with the current state of the others and returns
+// its new momentum, with its draw if it collided. The momenta of the other particles are left
+// to their own workers. The caller holds the mutex of the state.
+func (bmc *BrownianMonteCarlo) collideWithNeighbours(state *particles, id int) (ad.Vector, []Sample) {
+	Ps := make([]ad.Vector, bmc.NumParticles)
+	copy(Ps, state.Ps)
+	numCollisions := make([]int, bmc.NumParticles)
+	Ps, collidedSamples, numCollisions := bmc.Collide(state.Xs, Ps, bmc.Radius, bmc.Masses, numCollisions)
+	bmc.NumCollisions[id] += numCollisions[id]
+	collided := make([]Sample, 0)
+	for _, s := range collidedSamples {
+		if s.ID == id {
+			collided = append(collided, s)
+		}
+	}
+	return Ps[id], collided
+}
+
+// validate checks the configuration before sampling starts
+func (bmc *BrownianMonteCarlo) validate(dist distribution, initialX ad.Vector) error {
+	switch {
+	case dist == nil:
+		return configErrorf("no target distribution")
+	case bmc.Sampler == nil:
+		return configErrorf("no sampler")
+	case bmc.Collide == nil:
+		return configErrorf("no collision")
+	case bmc.NumParticles <= 0:
+		return configErrorf("number of particles must be positive, got %d", bmc.NumParticles)
+	case len(bmc.Radius) != bmc.NumParticles:
+		return configErrorf("%d radii for %d particles", len(bmc.Radius), bmc.NumParticles)
+	case len(bmc.Masses) != bmc.NumParticles:
+		return configErrorf("%d masses for %d particles", len(bmc.Masses), bmc.NumParticles)
+	case initialX == nil || initialX.Dim() == 0:
+		return configErrorf("no initial position")
+	case bmc.Names != nil && len(bmc.Names) != initialX.Dim():
+		return configErrorf("%d names for %d parameters", len(bmc.Names), initialX.Dim())
+	case bmc.GeneratedNames != nil && bmc.GeneratedQuantities == nil:
+		return configErrorf("names for generated quantities without GeneratedQuantities")
+	case bmc.Transform != nil && bmc.Transform.ConstrainedDim() != initialX.Dim():
+		return configErrorf("transform has %d constrained parameters, initial position has %d",
+			bmc.Transform.ConstrainedDim(), initialX.Dim())
+	case minibatchOf(bmc.Sampler) != nil && !bmc.LogDensity:
+		return configErrorf("the target of a Minibatch is a log density, LogDensity must be set")
+	case bmc.NumWarmup < 0:
+		return configErrorf("number of warmup iterations must not be negative, got %d", bmc.NumWarmup)
+	case bmc.Window < 0:
+		return configErrorf("window must not be negative, got %d", bmc.Window)
+	}
+	for i, mass := range bmc.Masses {
+		if !(mass.GetValue() > 0) {
+			return configErrorf("mass of particle %d must be positive, got %v", i, mass.GetValue())
+		}
+	}
+	return nil
+}
+
+// Err returns the error that ended sampling early, if any
+func (bmc *BrownianMonteCarlo) Err() error {
+	return bmc.err
+}
+
+// constrain returns the values of a draw in the constrained space
+func (bmc *BrownianMonteCarlo) constrain(x ad.Vector) []float64 {
+	if bmc.Transform == nil {
+		return x.GetValues()
+	}
+	return constrainValues(bmc.Transform, x.GetValues())
+}
+
+// countEvaluations wraps a potential energy to count its evaluations for a particle.
+// Each particle is only evaluated from its own goroutine.
+func (bmc *BrownianMonteCarlo) countEvaluations(id int, potentialEnergy logDistribution) logDistribution {
+	return func(x ad.Vector) ad.Scalar {
+		bmc.NumEvaluations[id]++
+		return potentialEnergy(x)
+	}
+}
+
+func (bmc *BrownianMonteCarlo) dualAveraging(constants adaptation, count int, delta ad.Scalar) {
+	for i := 0; i != bmc.NumParticles; i++ {
+		bmc.adaptStepSize(i, constants, count, delta)
+	}
+}
+
+// adaptStepSize updates the step size of particle id after its count-th transition
+func (bmc *BrownianMonteCarlo) adaptStepSize(id int, constants adaptation, count int, delta ad.Scalar) {
+	if count <= bmc.MaxAdapt {
+		mu, gamma, t0, kappa := constants.mu, constants.gamma, constants.t0, constants.kappa
+		m := ad.NewScalar(ad.RealType, float64(count))
+		temp := ads.Div(ad.NewReal(1), ads.Add(m, t0))
+		HBar := bmc.dualAvgVarList[id]["HBar"]
+		acceptance := bmc.dualAvgVarList[id]["acceptance"]
+		epsBar := bmc.dualAvgVarList[id]["epsBar"]
+		HBar = ads.Add(ads.Mul(HBar, ads.Sub(ad.NewReal(1), temp)), ads.Mul(temp, ads.Sub(delta, acceptance)))
+		logEps := ads.Sub(mu, ads.Mul(HBar, ads.Div(ads.Sqrt(m), gamma)))
+		logEpsBar := ads.Add(ads.Mul(logEps, ads.Pow(m, ads.Neg(kappa))), ads.Mul(ads.Log(epsBar), ads.Sub(ad.NewReal(1), ads.Pow(m, ads.Neg(kappa)))))
+		bmc.dualAvgVarList[id]["HBar"] = HBar
+		bmc.dualAvgVarList[id]["epsBar"] = ads.Exp(logEpsBar)
+		bmc.dualAvgVarList[id]["eps"] = ads.Exp(logEps)
+	}
+}
+
+// findReasonableEpsilon is the heuristic of Hoffman and Gelman (2014, Algorithm 4)
+func (bmc *BrownianMonteCarlo) findReasonableEpsilon(x ad.Vector) (eps ad.Scalar, err error) {
 	eps = ad.NewScalar(ad.RealType, 1)
-	p := sampleZeroMeanNormal(x.Dim(), ad.IdentityMatrix(ad.RealType, x.Dim()))
-	xPrime, pPrime := leapfrog(x, p, eps, bmc.potentialEnergy, bmc.Masses[0])
-	var a ad.Scalar
-	H1 := hamiltonian(xPrime, pPrime, bmc.Masses[0], bmc.potentialEnergy)
+	integrator := integratorOf(bmc.Sampler)
+	p := sampleMomentum(x.Dim(), bmc.Masses[0])
 	H0 := hamiltonian(x, p, bmc.Masses[0], bmc.potentialEnergy)
-	ratio := ads.Exp(ads.Sub(H0, H1))
-	if ratio.GetValue() > 0.5 {
+	ratio := func(eps ad.Scalar) ad.Scalar {
+		xPrime, pPrime := integrator.Integrate(clone(x), clone(p), eps, bmc.potentialEnergy, bmc.Masses[0])
+		H1 := hamiltonian(xPrime, pPrime, bmc.Masses[0], bmc.potentialEnergy)
+		if !isFinite(H1.GetValue()) {
+			return ad.NewScalar(ad.RealType, 0)
+		}
+		return ads.Exp(ads.Sub(H0, H1))
+	}
+	r := ratio(eps)
+	var a ad.Scalar
+	if r.GetValue() > 0.5 {
 		a = ad.NewScalar(ad.RealType, 1)
 	} else {
 		a = ad.NewScalar(ad.RealType, -1)
 	}
-	for ads.Pow(ratio, a).GetValue() > ads.Pow(ad.NewReal(2), ads.Neg(a)).GetValue() {
+	for i := 0; ads.Pow(r, a).GetValue() > ads.Pow(ad.NewReal(2), ads.Neg(a)).GetValue(); i++ {
+		if i == 100 {
+			return nil, &InitError{X: bmc.constrain(x), Reason: "no reasonable step size"}
+		}
 		eps = ads.Mul(eps, ads.Pow(ad.NewReal(2), a))
-		xPrime, pPrime = leapfrog(xPrime, pPrime, eps, bmc.potentialEnergy, bmc.Masses[0])
+		r = ratio(eps)
 	}
-	return eps
+	return eps, nil
 }
 
 // Stop stops sampling
 func (bmc *BrownianMonteCarlo) Stop() {
-	bmc.stop = true
+	if bmc.done == nil {
+		return
+	}
+	bmc.halt()
 	for {
 		select {
 		case _, ok := <-bmc.sample:
@@ -223,6 +762,25 @@ func (bmc *BrownianMonteCarlo) Stop() {
 	}
 }
 
+// record appends the current state of the particles to Frames
+func (bmc *BrownianMonteCarlo) record(Xs, Ps []ad.Vector, collided []Sample) {
+	frame := Frame{
+		Iteration: bmc.count,
+		X:         make([][]float64, bmc.NumParticles),
+		P:         make([][]float64, bmc.NumParticles),
+		Radius:    append([]float64{}, bmc.Radius...),
+		Collided:  make([]int, len(collided)),
+	}
+	for i := 0; i != bmc.NumParticles; i++ {
+		frame.X[i] = Xs[i].GetValues()
+		frame.P[i] = Ps[i].GetValues()
+	}
+	for k, s := range collided {
+		frame.Collided[k] = s.ID
+	}
+	bmc.Frames = append(bmc.Frames, frame)
+}
+
 // Mass returns mass of a particle
 func (bmc *BrownianMonteCarlo) Mass(id int) ad.Scalar {
 	return bmc.Masses[id]
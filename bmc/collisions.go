@@ -25,8 +25,7 @@ func NoCollision(
 ) ([]ad.Vector, []Sample, []int) {
 	collidedSamples := make([]Sample, 0)
 	for i := 0; i != len(Xs); i++ {
-		convMatrix := ads.MmulS(ad.IdentityMatrix(ad.RealType, Xs[i].Dim()), masses[i])
-		Ps[i] = sampleZeroMeanNormal(Xs[i].Dim(), convMatrix)
+		Ps[i] = sampleMomentum(Xs[i].Dim(), masses[i])
 	}
 	return Ps, collidedSamples, numCollisions
 }
@@ -94,8 +93,7 @@ func NormalCollision(
 	collidedSamples := make([]Sample, 0) // optional
 	for i, collide := range collision {
 		if !collide {
-			convMatrix := ads.MmulS(ad.IdentityMatrix(ad.RealType, Xs[i].Dim()), masses[i])
-			Ps[i] = sampleZeroMeanNormal(Xs[i].Dim(), convMatrix)
+			Ps[i] = sampleMomentum(Xs[i].Dim(), masses[i])
 		} else { // optional
 			collidedSamples = append(collidedSamples, Sample{ID: i, X: Xs[i].GetValues()})
 		}
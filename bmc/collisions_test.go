@@ -0,0 +1,71 @@
+package bmc
+
+import (
+	"math"
+	"testing"
+
+	ad "github.com/pbenner/autodiff"
+)
+
+func totalMomentum(Ps []ad.Vector) []float64 {
+	total := make([]float64, Ps[0].Dim())
+	for _, p := range Ps {
+		for i, v := range p.GetValues() {
+			total[i] += v
+		}
+	}
+	return total
+}
+
+func totalKineticEnergy(Ps []ad.Vector, masses []ad.Scalar) float64 {
+	total := 0.
+	for i, p := range Ps {
+		total += kineticEnergy(p, masses[i]).GetValue()
+	}
+	return total
+}
+
+// approachingPair returns two overlapping particles moving towards each other
+func approachingPair() (Xs, Ps []ad.Vector) {
+	Xs = []ad.Vector{
+		Float64ToVector([]float64{0, 0}),
+		Float64ToVector([]float64{0.5, 0.2}),
+	}
+	Ps = []ad.Vector{
+		Float64ToVector([]float64{1, 0.3}),
+		Float64ToVector([]float64{-0.8, 0.1}),
+	}
+	return Xs, Ps
+}
+
+func TestNormalCollisionConservesMomentum(t *testing.T) {
+	for _, m := range [][2]float64{{1, 1}, {1, 3}, {2.5, 0.5}} {
+		Xs, Ps := approachingPair()
+		masses := []ad.Scalar{ad.NewReal(m[0]), ad.NewReal(m[1])}
+		before := totalMomentum(Ps)
+		Ps, _, numCollisions := NormalCollision(Xs, Ps, []float64{1, 1}, masses, make([]int, 2))
+		if numCollisions[0] != 1 || numCollisions[1] != 1 {
+			t.Fatalf("masses %v: collisions = %v, want [1 1]", m, numCollisions)
+		}
+		assertClose(t, "total momentum", totalMomentum(Ps), before, 1e-12)
+	}
+}
+
+func TestNormalCollisionConservesEnergy(t *testing.T) {
+	Xs, Ps := approachingPair()
+	masses := []ad.Scalar{ad.NewReal(2), ad.NewReal(2)}
+	before := totalKineticEnergy(Ps, masses)
+	Ps, _, _ = NormalCollision(Xs, Ps, []float64{1, 1}, masses, make([]int, 2))
+	if after := totalKineticEnergy(Ps, masses); math.Abs(after-before) > 1e-12 {
+		t.Errorf("kinetic energy %v -> %v", before, after)
+	}
+}
+
+func TestNormalCollisionSeparated(t *testing.T) {
+	Xs, Ps := approachingPair()
+	// Radii too small to overlap: momenta are resampled, not exchanged
+	_, _, numCollisions := NormalCollision(Xs, Ps, []float64{0.1, 0.1}, []ad.Scalar{ad.NewReal(1), ad.NewReal(1)}, make([]int, 2))
+	if numCollisions[0] != 0 || numCollisions[1] != 0 {
+		t.Errorf("collisions = %v, want [0 0]", numCollisions)
+	}
+}
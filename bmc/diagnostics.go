@@ -0,0 +1,157 @@
+package bmc
+
+import "math"
+
+// Diagnostics summarizes the convergence of a run
+type Diagnostics struct {
+	// AcceptanceRate per particle
+	AcceptanceRate []float64
+	// RHat is the split potential scale reduction factor per dimension, particles are chains
+	RHat []float64
+	// ESS is the effective sample size per dimension over all particles
+	ESS []float64
+	// GeneratedRHat and GeneratedESS are RHat and ESS per generated quantity, nil without them
+	GeneratedRHat []float64
+	GeneratedESS  []float64
+}
+
+// NewDiagnostics computes diagnostics of draws indexed by [particle][draw][dim]
+func NewDiagnostics(draws [][][]float64, numAccepted, numRejected []int) Diagnostics {
+	diagnostics := Diagnostics{
+		AcceptanceRate: make([]float64, len(numAccepted)),
+		RHat:           RHat(draws),
+		ESS:            EffectiveSampleSize(draws),
+	}
+	for i := range numAccepted {
+		if total := numAccepted[i] + numRejected[i]; total > 0 {
+			diagnostics.AcceptanceRate[i] = float64(numAccepted[i]) / float64(total)
+		}
+	}
+	return diagnostics
+}
+
+// chainsOf returns the draws of one dimension as chains of equal length
+func chainsOf(draws [][][]float64, dim int) [][]float64 {
+	n := -1
+	for _, chain := range draws {
+		if n < 0 || len(chain) < n {
+			n = len(chain)
+		}
+	}
+	chains := make([][]float64, len(draws))
+	for j, chain := range draws {
+		chains[j] = make([]float64, n)
+		for i := 0; i != n; i++ {
+			chains[j][i] = chain[i][dim]
+		}
+	}
+	return chains
+}
+
+func dimOf(draws [][][]float64) int {
+	for _, chain := range draws {
+		if len(chain) > 0 {
+			return len(chain[0])
+		}
+	}
+	return 0
+}
+
+func meanAndVariance(xs []float64) (mean, variance float64) {
+	for _, x := range xs {
+		mean += x
+	}
+	mean /= float64(len(xs))
+	for _, x := range xs {
+		variance += (x - mean) * (x - mean)
+	}
+	variance /= float64(len(xs) - 1)
+	return mean, variance
+}
+
+// withinAndBetween returns the within-chain variance W and the pooled variance estimate var+
+func withinAndBetween(chains [][]float64) (W, varPlus float64) {
+	m, n := float64(len(chains)), float64(len(chains[0]))
+	means := make([]float64, len(chains))
+	grandMean := 0.
+	for j, chain := range chains {
+		mean, variance := meanAndVariance(chain)
+		means[j] = mean
+		grandMean += mean / m
+		W += variance / m
+	}
+	B := 0.
+	for _, mean := range means {
+		B += (mean - grandMean) * (mean - grandMean)
+	}
+	if m > 1 {
+		B *= n / (m - 1)
+	}
+	return W, (n-1)/n*W + B/n
+}
+
+// RHat computes the split R-hat of Gelman et al. per dimension. Draws are indexed by [chain][draw][dim].
+func RHat(draws [][][]float64) []float64 {
+	dim := dimOf(draws)
+	rhat := make([]float64, dim)
+	for d := 0; d != dim; d++ {
+		chains := chainsOf(draws, d)
+		if len(chains[0]) < 4 {
+			rhat[d] = math.NaN()
+			continue
+		}
+		half := len(chains[0]) / 2
+		split := make([][]float64, 0, 2*len(chains))
+		for _, chain := range chains {
+			split = append(split, chain[:half], chain[len(chain)-half:])
+		}
+		W, varPlus := withinAndBetween(split)
+		rhat[d] = math.Sqrt(varPlus / W)
+	}
+	return rhat
+}
+
+// EffectiveSampleSize computes the multi-chain effective sample size per dimension
+// with Geyer's initial monotone sequence. Draws are indexed by [chain][draw][dim].
+func EffectiveSampleSize(draws [][][]float64) []float64 {
+	dim := dimOf(draws)
+	ess := make([]float64, dim)
+	for d := 0; d != dim; d++ {
+		chains := chainsOf(draws, d)
+		m, n := len(chains), len(chains[0])
+		if n < 4 {
+			ess[d] = math.NaN()
+			continue
+		}
+		W, varPlus := withinAndBetween(chains)
+		means := make([]float64, m)
+		for j, chain := range chains {
+			means[j], _ = meanAndVariance(chain)
+		}
+		// autocorrelation at lag t combined over chains
+		rho := func(t int) float64 {
+			meanAutocov := 0.
+			for j, chain := range chains {
+				sum := 0.
+				for i := 0; i+t < n; i++ {
+					sum += (chain[i] - means[j]) * (chain[i+t] - means[j])
+				}
+				meanAutocov += sum / float64(n) / float64(m)
+			}
+			return 1 - (W-meanAutocov)/varPlus
+		}
+		tau := -1.
+		previous := math.Inf(1)
+		for t := 0; t+1 < n; t += 2 {
+			pair := rho(t) + rho(t+1)
+			if pair < 0 {
+				break
+			}
+			pair = math.Min(pair, previous)
+			previous = pair
+			tau += 2 * pair
+		}
+		ess[d] = float64(m*n) / math.Max(tau, 1/math.Log10(float64(m*n)))
+	}
+	return ess
+}
@@ -0,0 +1,39 @@
+package bmc
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestDiagnosticsIndependentDraws(t *testing.T) {
+	rand.Seed(1)
+	draws := make([][][]float64, 4)
+	for j := range draws {
+		for i := 0; i != 1000; i++ {
+			draws[j] = append(draws[j], []float64{rand.NormFloat64(), 3 + 2*rand.NormFloat64()})
+		}
+	}
+	for d, rhat := range RHat(draws) {
+		if math.Abs(rhat-1) > 0.02 {
+			t.Errorf("dim %d: R-hat %v of independent draws", d, rhat)
+		}
+	}
+	for d, ess := range EffectiveSampleSize(draws) {
+		if ess < 3000 || ess > 5000 {
+			t.Errorf("dim %d: ESS %v of 4000 independent draws", d, ess)
+		}
+	}
+}
+
+func TestRHatSeparatedChains(t *testing.T) {
+	draws := make([][][]float64, 2)
+	for j := range draws {
+		for i := 0; i != 100; i++ {
+			draws[j] = append(draws[j], []float64{10*float64(j) + rand.NormFloat64()})
+		}
+	}
+	if rhat := RHat(draws)[0]; rhat < 1.5 {
+		t.Errorf("R-hat %v of chains stuck in different modes", rhat)
+	}
+}
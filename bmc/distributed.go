@@ -0,0 +1,190 @@
+package bmc
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+
+	ad "github.com/pbenner/autodiff"
+)
+
+// StepArgs asks a worker for one transition of a particle.
+// Positions are in the space the sampler moves in, unconstrained if there is a Transform.
+type StepArgs struct {
+	ID       int
+	X, P     []float64
+	Mass     float64
+	StepSize float64
+}
+
+// StepReply is the outcome of a transition on a worker
+type StepReply struct {
+	X, P        []float64
+	Accepted    bool
+	Acceptance  float64
+	Divergent   bool
+	DivergenceX []float64
+	TreeDepth   int
+	// Potential is the potential energy at X on which the sampler moves
+	Potential float64
+	// NumEvaluations counts the evaluations of the potential energy during the transition
+	NumEvaluations int
+}
+
+// SamplerConfig identifies the sampler of a run. The coordinator compares its own with that of
+// every worker when it connects. Step sizes are not compared, they are sent with every transition.
+type SamplerConfig struct {
+	MCMC       string
+	NumSteps   int
+	Delta      float64
+	Integrator string
+	LogDensity bool
+}
+
+// samplerConfigOf returns the sampler configuration of config
+func samplerConfigOf(config BrownianMonteCarlo) SamplerConfig {
+	samplerConfig := SamplerConfig{
+		MCMC:       fmt.Sprintf("%T", config.Sampler),
+		Integrator: IntegratorName(config.Sampler),
+		LogDensity: config.LogDensity,
+	}
+	switch s := config.Sampler.(type) {
+	case HMC:
+		samplerConfig.NumSteps, samplerConfig.Delta = s.NumSteps, s.Delta
+	case NUTS:
+		samplerConfig.Delta = s.Delta
+	case RMHMC:
+		samplerConfig.NumSteps, samplerConfig.Delta = s.NumSteps, s.Delta
+	case SGHMC:
+		samplerConfig.NumSteps = s.NumSteps
+	}
+	return samplerConfig
+}
+
+// Worker runs transitions of particles for a coordinator over net/rpc.
+// The coordinator keeps positions, momenta, collisions, step size adaptation and statistics,
+// so a worker needs the same target, sampler, transform and LogDensity but no state of its own.
+// The exception is the adaptive covariance of RWM, which every worker estimates separately
+// from the transitions it runs.
+type Worker struct {
+	sampler       MCMC
+	config        SamplerConfig
+	samplerEnergy logDistribution
+}
+
+// NewWorker returns a worker that samples dist with the sampler of config,
+// with its Transform and LogDensity
+func NewWorker(dist distribution, config BrownianMonteCarlo) *Worker {
+	return &Worker{
+		sampler:       config.Sampler,
+		config:        samplerConfigOf(config),
+		samplerEnergy: samplerEnergyOf(dist, config),
+	}
+}
+
+// Handshake checks that the coordinator runs the sampler of the worker and replies with the latter
+func (worker *Worker) Handshake(config SamplerConfig, reply *SamplerConfig) error {
+	*reply = worker.config
+	if config != worker.config {
+		return fmt.Errorf("sampler %+v of the worker differs from %+v of the run", worker.config, config)
+	}
+	return nil
+}
+
+// Step runs one transition
+func (worker *Worker) Step(args StepArgs, reply *StepReply) error {
+	numEvaluations := 0
+	potentialEnergy := func(x ad.Vector) ad.Scalar {
+		numEvaluations++
+		return worker.samplerEnergy(x)
+	}
+	x, p, transition := worker.sampler.Sample(
+		ad.NewVector(ad.RealType, args.X),
+		ad.NewVector(ad.RealType, args.P),
+		ad.NewScalar(ad.RealType, args.Mass),
+		potentialEnergy,
+		ad.NewScalar(ad.RealType, args.StepSize),
+	)
+	*reply = StepReply{
+		X:              x.GetValues(),
+		P:              p.GetValues(),
+		Accepted:       transition.Accepted,
+		Acceptance:     transition.Acceptance.GetValue(),
+		Divergent:      transition.Divergent,
+		TreeDepth:      transition.TreeDepth,
+		Potential:      worker.samplerEnergy(x).GetValue(),
+		NumEvaluations: numEvaluations,
+	}
+	if transition.DivergenceX != nil {
+		reply.DivergenceX = transition.DivergenceX.GetValues()
+	}
+	return nil
+}
+
+// ServeWorker serves the transitions of worker on listener until the listener is closed
+func ServeWorker(listener net.Listener, worker *Worker) {
+	server := rpc.NewServer()
+	server.RegisterName("Worker", worker)
+	server.Accept(listener)
+}
+
+// dialWorkers connects to the worker processes of bmc.Workers and checks that they run its sampler
+func (bmc *BrownianMonteCarlo) dialWorkers() error {
+	bmc.workers = make([]*rpc.Client, 0, len(bmc.Workers))
+	config := samplerConfigOf(*bmc)
+	for _, address := range bmc.Workers {
+		client, err := rpc.Dial("tcp", address)
+		if err != nil {
+			bmc.closeWorkers()
+			return &WorkerError{Address: address, Particle: -1, Err: err}
+		}
+		bmc.workers = append(bmc.workers, client)
+		var reply SamplerConfig
+		if err := client.Call("Worker.Handshake", config, &reply); err != nil {
+			bmc.closeWorkers()
+			return &WorkerError{Address: address, Particle: -1, Err: err}
+		}
+	}
+	return nil
+}
+
+// closeWorkers disconnects from the worker processes
+func (bmc *BrownianMonteCarlo) closeWorkers() {
+	for _, client := range bmc.workers {
+		client.Close()
+	}
+	bmc.workers = nil
+}
+
+// moveRemotely runs the transition of particle id on its worker
+func (bmc *BrownianMonteCarlo) moveRemotely(id int, x, p ad.Vector) (ad.Vector, ad.Vector, Transition, ad.Scalar, error) {
+	k := id % len(bmc.workers)
+	// without adaptation the step size is that of the sampler of the coordinator, not of the worker
+	stepSize := bmc.dualAvgVarList[id]["eps"].GetValue()
+	if stepSize == 0 {
+		stepSize = stepSizeOf(bmc.Sampler)
+	}
+	args := StepArgs{
+		ID:       id,
+		X:        x.GetValues(),
+		P:        p.GetValues(),
+		Mass:     bmc.Masses[id].GetValue(),
+		StepSize: stepSize,
+	}
+	var reply StepReply
+	if err := bmc.workers[k].Call("Worker.Step", args, &reply); err != nil {
+		return nil, nil, Transition{}, nil, &WorkerError{Address: bmc.Workers[k], Particle: id, Err: err}
+	}
+	bmc.NumEvaluations[id] += reply.NumEvaluations
+	transition := Transition{
+		Accepted:   reply.Accepted,
+		Acceptance: ad.NewScalar(ad.RealType, reply.Acceptance),
+		Divergent:  reply.Divergent,
+		TreeDepth:  reply.TreeDepth,
+	}
+	if reply.DivergenceX != nil {
+		transition.DivergenceX = ad.NewVector(ad.RealType, reply.DivergenceX)
+	}
+	return ad.NewVector(ad.RealType, reply.X), ad.NewVector(ad.RealType, reply.P), transition,
+		ad.NewScalar(ad.RealType, reply.Potential), nil
+}
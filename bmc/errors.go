@@ -0,0 +1,63 @@
+package bmc
+
+import "fmt"
+
+// ConfigError reports an invalid configuration
+type ConfigError struct {
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return "bmc: invalid configuration: " + e.Reason
+}
+
+func configErrorf(format string, args ...interface{}) error {
+	return &ConfigError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// MetricError reports a covariance or metric that is not positive definite
+type MetricError struct {
+	Dim int
+}
+
+func (e *MetricError) Error() string {
+	return fmt.Sprintf("bmc: %dx%d metric is not positive definite", e.Dim, e.Dim)
+}
+
+// InitError reports a starting point where sampling cannot begin
+type InitError struct {
+	X      []float64
+	Reason string
+}
+
+func (e *InitError) Error() string {
+	return fmt.Sprintf("bmc: cannot start at %v: %s", e.X, e.Reason)
+}
+
+// DivergenceError reports a particle whose transitions kept diverging
+type DivergenceError struct {
+	Particle       int
+	Iteration      int
+	NumDivergences int
+	X              []float64
+}
+
+func (e *DivergenceError) Error() string {
+	return fmt.Sprintf("bmc: particle %d diverged %d times in a row up to iteration %d at %v",
+		e.Particle, e.NumDivergences, e.Iteration, e.X)
+}
+
+// WorkerError reports a worker process that could not be reached or failed a transition
+type WorkerError struct {
+	Address string
+	// Particle is the particle of the failed transition, -1 when connecting
+	Particle int
+	Err      error
+}
+
+func (e *WorkerError) Error() string {
+	if e.Particle < 0 {
+		return fmt.Sprintf("bmc: worker %s: %v", e.Address, e.Err)
+	}
+	return fmt.Sprintf("bmc: worker %s, particle %d: %v", e.Address, e.Particle, e.Err)
+}
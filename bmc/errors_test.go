@@ -0,0 +1,49 @@
+package bmc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	ad "github.com/pbenner/autodiff"
+	ads "github.com/pbenner/autodiff/simple"
+)
+
+func TestSampleZeroMeanNormalMetricError(t *testing.T) {
+	covariance := ad.NewMatrix(ad.RealType, 2, 2, []float64{1, 2, 2, 1})
+	var metricError *MetricError
+	if _, err := sampleZeroMeanNormal(2, covariance); !errors.As(err, &metricError) {
+		t.Errorf("got %v, want *MetricError", err)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	var configError *ConfigError
+	options := validOptions()
+	options.Radius = nil
+	if _, err := Run(context.Background(), func(x ad.Vector) ad.Scalar { return ads.Exp(ads.Neg(standardNormal(x))) }, options); !errors.As(err, &configError) {
+		t.Errorf("mismatched radii: got %v, want *ConfigError", err)
+	}
+
+	// The density vanishes at the origin, so the potential energy is infinite
+	zero := func(x ad.Vector) ad.Scalar { return ads.Mul(ad.NewReal(0), ads.Exp(ads.Neg(standardNormal(x)))) }
+	var initError *InitError
+	if _, err := Run(context.Background(), zero, validOptions()); !errors.As(err, &initError) {
+		t.Errorf("zero density: got %v, want *InitError", err)
+	}
+
+	// NaN away from the origin makes every transition diverge
+	nan := func(x ad.Vector) ad.Scalar {
+		if x.GetValues()[0] != 0 {
+			return ad.NewReal(math.NaN())
+		}
+		return ad.NewReal(1)
+	}
+	var divergenceError *DivergenceError
+	options = validOptions()
+	options.NumDraws = 1000
+	if _, err := Run(context.Background(), nan, options); !errors.As(err, &divergenceError) {
+		t.Errorf("NaN density: got %v, want *DivergenceError", err)
+	}
+}
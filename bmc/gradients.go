@@ -0,0 +1,135 @@
+package bmc
+
+import (
+	"math"
+	"math/rand"
+
+	ad "github.com/pbenner/autodiff"
+	ads "github.com/pbenner/autodiff/simple"
+)
+
+// FiniteDifferences adapts a log density that autodiff cannot trace, such as a simulator,
+// to a Target. Its gradient is estimated by central differences with step h, at the cost
+// of 2*dim evaluations of logDensity per evaluation of the target. The Target returns
+// a log density, so sample it with LogDensity set. It provides first derivatives only,
+// its Hessian is zero, so it does not suit RMHMC, whose metric is the Hessian.
+func FiniteDifferences(logDensity func(x []float64) float64, h float64) Target {
+	return func(x ad.Vector) ad.Scalar {
+		x0 := x.GetValues()
+		value := logDensity(x0)
+		gradient := make([]float64, len(x0))
+		if isFinite(value) {
+			xh := append([]float64{}, x0...)
+			for i := range xh {
+				xh[i] = x0[i] + h
+				forward := logDensity(xh)
+				xh[i] = x0[i] - h
+				backward := logDensity(xh)
+				xh[i] = x0[i]
+				gradient[i] = (forward - backward) / (2 * h)
+			}
+		}
+		// value + gradient'(x - x0) carries the estimated gradient as derivatives of x
+		displacement := ads.VsubV(x, ad.NewVector(ad.RealType, x0))
+		return ads.Add(ad.NewReal(value), ads.VdotV(ad.NewVector(ad.RealType, gradient), displacement))
+	}
+}
+
+// Minibatch is a posterior whose log likelihood is a sum over a dataset.
+// Stochastic gradient samplers estimate its gradient on random batches.
+type Minibatch struct {
+	// LogPrior is the log prior density, nil means flat
+	LogPrior func(x ad.Vector) ad.Scalar
+	// LogLikelihood is the log likelihood of data point i
+	LogLikelihood func(x ad.Vector, i int) ad.Scalar
+	NumData       int
+	BatchSize     int
+}
+
+// Target returns the log density of the full posterior, to be sampled with LogDensity set
+func (minibatch Minibatch) Target() Target {
+	return func(x ad.Vector) ad.Scalar {
+		indices := make([]int, minibatch.NumData)
+		for i := range indices {
+			indices[i] = i
+		}
+		return minibatch.logDensity(x, indices, 1)
+	}
+}
+
+// batchTarget returns the log density estimated on a new random batch at every evaluation
+func (minibatch Minibatch) batchTarget() Target {
+	batchSize := minibatch.BatchSize
+	if batchSize <= 0 || batchSize > minibatch.NumData {
+		batchSize = minibatch.NumData
+	}
+	scale := float64(minibatch.NumData) / float64(batchSize)
+	return func(x ad.Vector) ad.Scalar {
+		return minibatch.logDensity(x, rand.Perm(minibatch.NumData)[:batchSize], scale)
+	}
+}
+
+// logDensity is the log prior plus the scaled log likelihood of the data points in indices
+func (minibatch Minibatch) logDensity(x ad.Vector, indices []int, scale float64) ad.Scalar {
+	logLikelihood := ad.NewScalar(ad.RealType, 0)
+	for _, i := range indices {
+		logLikelihood = ads.Add(logLikelihood, minibatch.LogLikelihood(x, i))
+	}
+	logDensity := ads.Mul(ad.NewReal(scale), logLikelihood)
+	if minibatch.LogPrior != nil {
+		logDensity = ads.Add(logDensity, minibatch.LogPrior(x))
+	}
+	return logDensity
+}
+
+// SGHMC denotes stochastic gradient Hamiltonian Monte Carlo (Chen et al., 2014).
+// Friction and injected noise replace the Metropolis correction, so every transition is
+// accepted and the step size must be fixed. With a Minibatch BMC hands SGHMC a potential energy
+// that is estimated on a new random batch at every evaluation, so every step estimates the
+// gradient on a new batch, otherwise the full gradient of the target is used.
+type SGHMC struct {
+	StepSize ad.Scalar
+	NumSteps int
+	// Friction is the friction coefficient C (default 1)
+	Friction  float64
+	Minibatch *Minibatch
+}
+
+// Sample samples from target distribution
+func (sghmc SGHMC) Sample(
+	initialX, initialP ad.Vector,
+	mass ad.Scalar,
+	potentialEnergy logDistribution,
+	stepSize ad.Scalar,
+) (x, p ad.Vector, transition Transition) {
+	if stepSize.GetValue() != 0 {
+		sghmc.StepSize = stepSize
+	}
+	if sghmc.Friction == 0 {
+		sghmc.Friction = 1
+	}
+	eps := sghmc.StepSize.GetValue()
+	m := mass.GetValue()
+	noise := math.Sqrt(2 * sghmc.Friction * eps)
+	xs := append([]float64{}, initialX.GetValues()...)
+	ps := append([]float64{}, initialP.GetValues()...)
+	for i := 0; i != sghmc.NumSteps; i++ {
+		previousX := ad.NewVector(ad.RealType, append([]float64{}, xs...))
+		grad := gradients(potentialEnergy, ad.NewVector(ad.RealType, append([]float64{}, xs...))).GetValues()
+		finite := true
+		for j := range ps {
+			ps[j] += -eps*grad[j] - eps*sghmc.Friction*ps[j]/m + noise*rand.NormFloat64()
+			xs[j] += eps * ps[j] / m
+			finite = finite && isFinite(xs[j]) && isFinite(ps[j])
+		}
+		if !finite {
+			transition.Divergent = true
+			transition.DivergenceX = previousX
+			transition.Acceptance = ad.NewScalar(ad.RealType, 0)
+			return initialX, initialP, transition
+		}
+	}
+	transition.Accepted = true
+	transition.Acceptance = ad.NewScalar(ad.RealType, 1)
+	return ad.NewVector(ad.RealType, xs), ad.NewVector(ad.RealType, ps), transition
+}
@@ -0,0 +1,115 @@
+package bmc
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+
+	ad "github.com/pbenner/autodiff"
+	ads "github.com/pbenner/autodiff/simple"
+)
+
+func TestFiniteDifferences(t *testing.T) {
+	logDensity := func(x []float64) float64 {
+		r2 := x[0]*x[0] + x[1]*x[1]
+		return -0.25*r2*r2 - 0.5*r2
+	}
+	potentialEnergy := minusDist(FiniteDifferences(logDensity, 1e-5))
+	for _, x := range [][]float64{{0, 0}, {1, -0.5}, {-2, 1.5}} {
+		got := gradients(potentialEnergy, Float64ToVector(x)).GetValues()
+		want := gradients(anharmonic, Float64ToVector(x)).GetValues()
+		assertClose(t, "gradient", got, want, 1e-5)
+		if value := potentialEnergy(Float64ToVector(x)).GetValue(); math.Abs(value+logDensity(x)) > 1e-12 {
+			t.Errorf("potential energy at %v is %v, want %v", x, value, -logDensity(x))
+		}
+	}
+}
+
+func TestMinibatchFullBatch(t *testing.T) {
+	data := []float64{-1, 0.5, 2, 3}
+	minibatch := Minibatch{
+		LogLikelihood: func(x ad.Vector, i int) ad.Scalar {
+			d := ads.VsubV(x, ad.NewVector(ad.RealType, []float64{data[i]}))
+			return ads.Mul(ad.NewReal(-0.5), ads.VdotV(d, d))
+		},
+		NumData:   len(data),
+		BatchSize: len(data),
+	}
+	x := []float64{0.3}
+	got := gradients(minusDist(minibatch.batchTarget()), Float64ToVector(x)).GetValues()
+	want := gradients(minusDist(minibatch.Target()), Float64ToVector(x)).GetValues()
+	assertClose(t, "gradient", got, want, 1e-12)
+	// the gradient of sum (x - d_i)^2 / 2 is sum (x - d_i)
+	assertClose(t, "gradient", got, []float64{4*0.3 - 4.5}, 1e-12)
+}
+
+func TestMinibatchTransform(t *testing.T) {
+	data := []float64{0.5, 1, 2}
+	minibatch := &Minibatch{
+		LogLikelihood: func(x ad.Vector, i int) ad.Scalar {
+			// exponential likelihood of rate x
+			rate := ads.VdotV(ad.NewVector(ad.RealType, []float64{1}), x)
+			return ads.Sub(ads.Log(rate), ads.Mul(ad.NewReal(data[i]), rate))
+		},
+		NumData:   len(data),
+		BatchSize: len(data),
+	}
+	transform := LogTransform{Dim: 1}
+	config := BrownianMonteCarlo{Sampler: SGHMC{StepSize: ad.NewReal(0.01), NumSteps: 1, Minibatch: minibatch}, Transform: transform}
+	got := samplerEnergyOf(minibatch.Target(), config)
+	want := potentialEnergyOf(minibatch.Target(), true, transform)
+	for _, y := range [][]float64{{-1}, {0.2}, {1.5}} {
+		assertClose(t, "potential energy", []float64{got(Float64ToVector(y)).GetValue()}, []float64{want(Float64ToVector(y)).GetValue()}, 1e-12)
+		assertClose(t, "gradient", gradients(got, Float64ToVector(y)).GetValues(), gradients(want, Float64ToVector(y)).GetValues(), 1e-12)
+	}
+}
+
+func TestRunMinibatch(t *testing.T) {
+	rand.Seed(1)
+	data := make([]float64, 20)
+	for i := range data {
+		data[i] = 1 + rand.NormFloat64()
+	}
+	minibatch := &Minibatch{
+		LogLikelihood: func(x ad.Vector, i int) ad.Scalar {
+			d := ads.VsubV(x, ad.NewVector(ad.RealType, []float64{data[i]}))
+			return ads.Mul(ad.NewReal(-0.5), ads.VdotV(d, d))
+		},
+		NumData:   len(data),
+		BatchSize: 5,
+	}
+	options := validOptions()
+	options.Sampler = SGHMC{StepSize: ad.NewReal(0.02), NumSteps: 10, Minibatch: minibatch}
+	options.Dim = 1
+	options.NumWarmup = 50
+	options.NumDraws = 300
+	if _, err := Run(context.Background(), minibatch.Target(), options); err == nil {
+		t.Fatal("minibatch without LogDensity: no error")
+	}
+	options.LogDensity = true
+	result, err := Run(context.Background(), minibatch.Target(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// with a flat prior the posterior of the mean is normal around the mean of the data with variance 1/20
+	dataMean, drawMean, n := 0., 0., 0.
+	for _, v := range data {
+		dataMean += v / float64(len(data))
+	}
+	for i, draws := range result.Draws {
+		if len(draws) != options.NumDraws {
+			t.Fatalf("particle %d: %d draws, want %d", i, len(draws), options.NumDraws)
+		}
+		for _, x := range draws {
+			drawMean += x[0]
+			n++
+		}
+		if result.NumEvaluations[i] == 0 {
+			t.Errorf("particle %d: no evaluations counted", i)
+		}
+	}
+	if drawMean /= n; math.Abs(drawMean-dataMean) > 0.3 {
+		t.Errorf("posterior mean %v, want %v", drawMean, dataMean)
+	}
+}
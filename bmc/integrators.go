@@ -0,0 +1,125 @@
+package bmc
+
+import (
+	"math"
+
+	ad "github.com/pbenner/autodiff"
+	ads "github.com/pbenner/autodiff/simple"
+)
+
+// Integrator is an interface of symplectic integrators of Hamiltonian dynamics
+type Integrator interface {
+	Integrate(
+		position, momentum ad.Vector,
+		stepSize ad.Scalar,
+		potentialEnergy logDistribution,
+		mass ad.Scalar,
+	) (x, p ad.Vector)
+}
+
+// Leapfrog is the Stormer-Verlet integrator (second order). Every step evaluates two gradients,
+// since the last gradient of a step is not reused by the next one.
+type Leapfrog struct{}
+
+// Integrate takes a single leapfrog step
+func (Leapfrog) Integrate(
+	position, momentum ad.Vector,
+	stepSize ad.Scalar,
+	potentialEnergy logDistribution,
+	mass ad.Scalar,
+) (x, p ad.Vector) {
+	return leapfrog(position, momentum, stepSize, potentialEnergy, mass)
+}
+
+// MinimalNorm is the two-stage minimal-norm integrator of Blanes et al. (2014).
+// It is second order with three gradients per step (two if the last one were reused),
+// but has a much smaller energy error than leapfrog.
+type MinimalNorm struct{}
+
+// minimalNormLambda minimizes the norm of the leading error term
+const minimalNormLambda = 0.19318332750378364
+
+// Integrate takes a single two-stage step
+func (MinimalNorm) Integrate(
+	position, momentum ad.Vector,
+	stepSize ad.Scalar,
+	potentialEnergy logDistribution,
+	mass ad.Scalar,
+) (x, p ad.Vector) {
+	kick := func(x, p ad.Vector, weight float64) ad.Vector {
+		grad := gradients(potentialEnergy, x)
+		return ads.VsubV(p, ads.VmulS(grad, ads.Mul(ad.NewReal(weight), stepSize)))
+	}
+	drift := func(x, p ad.Vector, weight float64) ad.Vector {
+		return ads.VaddV(x, ads.VmulS(ads.VdivS(p, mass), ads.Mul(ad.NewReal(weight), stepSize)))
+	}
+	p = kick(position, momentum, minimalNormLambda)
+	x = drift(position, p, 0.5)
+	p = kick(x, p, 1-2*minimalNormLambda)
+	x = drift(x, p, 0.5)
+	p = kick(x, p, minimalNormLambda)
+	return x, p
+}
+
+// Yoshida is the fourth-order integrator of Yoshida (1990), a symmetric composition of three leapfrog steps
+// with six gradients per step
+type Yoshida struct{}
+
+// Integrate takes a single fourth-order step
+func (Yoshida) Integrate(
+	position, momentum ad.Vector,
+	stepSize ad.Scalar,
+	potentialEnergy logDistribution,
+	mass ad.Scalar,
+) (x, p ad.Vector) {
+	cbrt2 := math.Cbrt(2)
+	w1 := 1 / (2 - cbrt2)
+	w0 := -cbrt2 / (2 - cbrt2)
+	x, p = leapfrog(position, momentum, ads.Mul(ad.NewReal(w1), stepSize), potentialEnergy, mass)
+	x, p = leapfrog(x, p, ads.Mul(ad.NewReal(w0), stepSize), potentialEnergy, mass)
+	x, p = leapfrog(x, p, ads.Mul(ad.NewReal(w1), stepSize), potentialEnergy, mass)
+	return x, p
+}
+
+// GetIntegrator gets name of integrator and return corresponding integrator
+func GetIntegrator(name string) Integrator {
+	switch name {
+	case "Leapfrog":
+		return Leapfrog{}
+	case "MinimalNorm":
+		return MinimalNorm{}
+	case "Yoshida":
+		return Yoshida{}
+	default:
+		return nil
+	}
+}
+
+// integratorOf returns the integrator of a sampler, which is leapfrog unless set
+func integratorOf(sampler MCMC) Integrator {
+	var integrator Integrator
+	switch s := sampler.(type) {
+	case HMC:
+		integrator = s.Integrator
+	case NUTS:
+		integrator = s.Integrator
+	}
+	if integrator == nil {
+		return Leapfrog{}
+	}
+	return integrator
+}
+
+// IntegratorName returns the name of the integrator used by a sampler
+func IntegratorName(sampler MCMC) string {
+	switch integratorOf(sampler).(type) {
+	case Leapfrog:
+		return "Leapfrog"
+	case MinimalNorm:
+		return "MinimalNorm"
+	case Yoshida:
+		return "Yoshida"
+	default:
+		return "UndefinedIntegrator"
+	}
+}
@@ -0,0 +1,55 @@
+package bmc
+
+import (
+	"math"
+	"testing"
+
+	ad "github.com/pbenner/autodiff"
+)
+
+var integrators = map[string]Integrator{
+	"Leapfrog":    Leapfrog{},
+	"MinimalNorm": MinimalNorm{},
+	"Yoshida":     Yoshida{},
+}
+
+func TestIntegratorsReversible(t *testing.T) {
+	for name, integrator := range integrators {
+		x0 := []float64{0.3, -1.2}
+		p0 := []float64{0.7, 0.4}
+		x, p := Float64ToVector(append([]float64{}, x0...)), Float64ToVector(append([]float64{}, p0...))
+		for i := 0; i != 20; i++ {
+			x, p = integrator.Integrate(x, p, ad.NewReal(0.1), anharmonic, ad.NewReal(1.5))
+		}
+		p = Float64ToVector([]float64{-p.GetValues()[0], -p.GetValues()[1]})
+		for i := 0; i != 20; i++ {
+			x, p = integrator.Integrate(x, p, ad.NewReal(0.1), anharmonic, ad.NewReal(1.5))
+		}
+		assertClose(t, name+" x", x.GetValues(), x0, 1e-9)
+		assertClose(t, name+" p", []float64{-p.GetValues()[0], -p.GetValues()[1]}, p0, 1e-9)
+	}
+}
+
+// maxEnergyError integrates a fixed trajectory length and returns the largest energy error
+func maxEnergyError(integrator Integrator, stepSize float64) float64 {
+	x := Float64ToVector([]float64{1, -0.5})
+	p := Float64ToVector([]float64{0.2, 0.8})
+	mass := ad.NewReal(1)
+	H0 := hamiltonian(x, p, mass, anharmonic).GetValue()
+	maxError := 0.
+	for i := 0; i != int(2/stepSize); i++ {
+		x, p = integrator.Integrate(x, p, ad.NewReal(stepSize), anharmonic, mass)
+		maxError = math.Max(maxError, math.Abs(hamiltonian(x, p, mass, anharmonic).GetValue()-H0))
+	}
+	return maxError
+}
+
+func TestIntegratorsOrder(t *testing.T) {
+	// Halving the step size reduces the energy error by 2^order
+	for name, order := range map[string]float64{"Leapfrog": 2, "MinimalNorm": 2, "Yoshida": 4} {
+		ratio := maxEnergyError(integrators[name], 0.05) / maxEnergyError(integrators[name], 0.025)
+		if ratio < math.Pow(2, order)*0.7 {
+			t.Errorf("%s: error ratio %v, want about %v", name, ratio, math.Pow(2, order))
+		}
+	}
+}
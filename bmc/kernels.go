@@ -0,0 +1,224 @@
+package bmc
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+
+	ad "github.com/pbenner/autodiff"
+	ads "github.com/pbenner/autodiff/simple"
+	"gonum.org/v1/gonum/mat"
+)
+
+// MomentumFree is implemented by MCMC samplers that do not move along momenta.
+// BMC neither resamples momenta nor runs collisions for them, so particles are independent chains.
+type MomentumFree interface {
+	MCMC
+	MomentumFree()
+}
+
+// MALA denotes Metropolis-adjusted Langevin algorithm.
+// A Langevin proposal is a single leapfrog step from the given momentum, so collisions
+// change the proposal noise in the same way as they change the momentum of HMC.
+type MALA struct {
+	StepSize ad.Scalar
+}
+
+// Sample samples from target distribution
+func (mala MALA) Sample(
+	initialX, initialP ad.Vector,
+	mass ad.Scalar,
+	potentialEnergy logDistribution,
+	stepSize ad.Scalar,
+) (x, p ad.Vector, transition Transition) {
+	hmc := HMC{StepSize: mala.StepSize, NumSteps: 1, Integrator: Leapfrog{}}
+	return hmc.Sample(initialX, initialP, mass, potentialEnergy, stepSize)
+}
+
+// RWM denotes random-walk Metropolis whose proposal covariance adapts to the draws (Haario et al., 2001).
+// The proposal is x + stepSize * L * p / sqrt(mass) where LL' is the proposal covariance,
+// so the momentum given by collisions is the direction of the random walk.
+type RWM struct {
+	StepSize ad.Scalar
+	// Adapt is the number of transitions, pooled over particles, during which the covariance adapts
+	Adapt int
+
+	covariance *adaptiveCovariance
+}
+
+// NewRWM creates a random-walk Metropolis sampler with an adaptive proposal covariance
+func NewRWM(stepSize ad.Scalar, adapt int) RWM {
+	return RWM{
+		StepSize:   stepSize,
+		Adapt:      adapt,
+		covariance: &adaptiveCovariance{},
+	}
+}
+
+// Sample samples from target distribution
+func (rwm RWM) Sample(
+	initialX, initialP ad.Vector,
+	mass ad.Scalar,
+	potentialEnergy logDistribution,
+	stepSize ad.Scalar,
+) (x, p ad.Vector, transition Transition) {
+	if stepSize.GetValue() != 0 {
+		rwm.StepSize = stepSize
+	}
+	dim := initialX.Dim()
+	direction := initialP.GetValues()
+	if rwm.covariance != nil {
+		if L := rwm.covariance.cholesky(); L != nil {
+			direction = mulVec(L, direction)
+		}
+	}
+	scale := rwm.StepSize.GetValue() / math.Sqrt(mass.GetValue())
+	proposal := make([]float64, dim)
+	for i, v := range initialX.GetValues() {
+		proposal[i] = v + scale*direction[i]
+	}
+	x = Float64ToVector(proposal)
+
+	// The drift leaves the kinetic energy unchanged
+	deltaU := potentialEnergy(x).GetValue() - potentialEnergy(initialX).GetValue()
+	if -deltaU >= math.Log(1-rand.Float64()) {
+		p = ads.VmulS(initialP, ad.NewReal(-1))
+		transition.Accepted = true
+	} else {
+		x = initialX
+		p = initialP
+	}
+	transition.Acceptance = ad.NewReal(math.Min(1, math.Exp(-deltaU)))
+	if rwm.covariance != nil {
+		rwm.covariance.update(x.GetValues(), rwm.Adapt)
+	}
+	return x, p, transition
+}
+
+// adaptiveCovariance is a running covariance shared by all particles
+type adaptiveCovariance struct {
+	mutex sync.Mutex
+	n     int
+	mean  []float64
+	m2    [][]float64
+	chol  *mat.TriDense
+}
+
+func (c *adaptiveCovariance) update(x []float64, adapt int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.n >= adapt {
+		return
+	}
+	dim := len(x)
+	if c.mean == nil {
+		c.mean = make([]float64, dim)
+		c.m2 = make([][]float64, dim)
+		for i := range c.m2 {
+			c.m2[i] = make([]float64, dim)
+		}
+	}
+	// Welford's algorithm
+	c.n++
+	delta := make([]float64, dim)
+	for i := range x {
+		delta[i] = x[i] - c.mean[i]
+		c.mean[i] += delta[i] / float64(c.n)
+	}
+	for i := range x {
+		for j := range x {
+			c.m2[i][j] += delta[i] * (x[j] - c.mean[j])
+		}
+	}
+	// Refresh the factor once there are enough draws
+	if c.n > 2*dim {
+		scale := 2.38 * 2.38 / float64(dim) / float64(c.n-1)
+		covariance := mat.NewSymDense(dim, nil)
+		for i := 0; i != dim; i++ {
+			for j := i; j != dim; j++ {
+				covariance.SetSym(i, j, scale*c.m2[i][j])
+			}
+			covariance.SetSym(i, i, covariance.At(i, i)+1e-6)
+		}
+		var chol mat.Cholesky
+		if chol.Factorize(covariance) {
+			L := mat.NewTriDense(dim, mat.Lower, nil)
+			chol.LTo(L)
+			c.chol = L
+		}
+	}
+}
+
+// cholesky returns the lower Cholesky factor of the covariance, nil until there are enough draws
+func (c *adaptiveCovariance) cholesky() *mat.TriDense {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.chol
+}
+
+// Slice denotes univariate slice sampling with stepping out (Neal, 2003), applied to each coordinate in turn.
+// It does not use momenta, so BMC runs it without collisions.
+type Slice struct {
+	// Width is the initial width of the bracket
+	Width float64
+	// MaxSteps limits the number of steps out (default 100), so that stepping out
+	// ends on flat or improper densities
+	MaxSteps int
+}
+
+// MomentumFree marks Slice as a sampler that does not use momenta
+func (slice Slice) MomentumFree() {}
+
+// Sample samples from target distribution
+func (slice Slice) Sample(
+	initialX, initialP ad.Vector,
+	mass ad.Scalar,
+	potentialEnergy logDistribution,
+	stepSize ad.Scalar,
+) (x, p ad.Vector, transition Transition) {
+	width := slice.Width
+	if width == 0 {
+		width = 1
+	}
+	maxSteps := slice.MaxSteps
+	if maxSteps == 0 {
+		maxSteps = 100
+	}
+	current := append([]float64{}, initialX.GetValues()...)
+	logDensity := func(v []float64) float64 {
+		return -potentialEnergy(Float64ToVector(v)).GetValue()
+	}
+	at := func(i int, value float64) []float64 {
+		v := append([]float64{}, current...)
+		v[i] = value
+		return v
+	}
+	for i := range current {
+		logy := logDensity(current) + math.Log(1-rand.Float64())
+		// Step out
+		left := current[i] - width*rand.Float64()
+		right := left + width
+		j := rand.Intn(maxSteps)
+		k := maxSteps - 1 - j
+		for ; j > 0 && logDensity(at(i, left)) > logy; j-- {
+			left -= width
+		}
+		for ; k > 0 && logDensity(at(i, right)) > logy; k-- {
+			right += width
+		}
+		// Shrink
+		for {
+			candidate := left + (right-left)*rand.Float64()
+			if logDensity(at(i, candidate)) > logy {
+				current[i] = candidate
+				break
+			}
+			if candidate < current[i] {
+				left = candidate
+			} else {
+				right = candidate
+			}
+		}
+	}
+	return Float64ToVector(current), initialP, Transition{Accepted: true, Acceptance: ad.NewReal(1)}
+}
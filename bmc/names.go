@@ -0,0 +1,66 @@
+package bmc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ExpandNames labels the values of parameters declared by name and shape, such as
+// "beta[3]", "L[2,2]" or "sigma". Vectors expand to beta[1], beta[2], beta[3]
+// and matrices to L[1,1], L[1,2], ... in row-major order.
+func ExpandNames(declarations ...string) ([]string, error) {
+	names := make([]string, 0)
+	for _, declaration := range declarations {
+		open := strings.Index(declaration, "[")
+		if open < 0 {
+			if declaration == "" {
+				return nil, fmt.Errorf("bmc: empty parameter name")
+			}
+			names = append(names, declaration)
+			continue
+		}
+		name := declaration[:open]
+		if name == "" || !strings.HasSuffix(declaration, "]") {
+			return nil, fmt.Errorf("bmc: parameter %q is not name[n] or name[n,m]", declaration)
+		}
+		fields := strings.Split(declaration[open+1:len(declaration)-1], ",")
+		shape := make([]int, len(fields))
+		for i, field := range fields {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("bmc: parameter %q has an invalid shape", declaration)
+			}
+			shape[i] = n
+		}
+		// count through the indices in row-major order
+		index := make([]int, len(shape))
+		for {
+			labels := make([]string, len(index))
+			for i, k := range index {
+				labels[i] = strconv.Itoa(k + 1)
+			}
+			names = append(names, name+"["+strings.Join(labels, ",")+"]")
+			i := len(index) - 1
+			for ; i >= 0; i-- {
+				index[i]++
+				if index[i] < shape[i] {
+					break
+				}
+				index[i] = 0
+			}
+			if i < 0 {
+				break
+			}
+		}
+	}
+	return names, nil
+}
+
+// BaseName returns the name of the parameter block of a label, beta for beta[2]
+func BaseName(label string) string {
+	if open := strings.Index(label, "["); open >= 0 {
+		return label[:open]
+	}
+	return label
+}
@@ -0,0 +1,30 @@
+package bmc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandNames(t *testing.T) {
+	names, err := ExpandNames("beta[3]", "sigma", "L[2,2]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"beta[1]", "beta[2]", "beta[3]", "sigma", "L[1,1]", "L[1,2]", "L[2,1]", "L[2,2]"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("names %v, want %v", names, want)
+	}
+	for _, declaration := range []string{"beta[0]", "beta[", "[2]", "L[2,x]", ""} {
+		if _, err := ExpandNames(declaration); err == nil {
+			t.Errorf("%q: no error", declaration)
+		}
+	}
+}
+
+func TestBaseName(t *testing.T) {
+	for label, want := range map[string]string{"beta[2]": "beta", "L[1,2]": "L", "sigma": "sigma"} {
+		if got := BaseName(label); got != want {
+			t.Errorf("BaseName(%q) = %q, want %q", label, got, want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package bmc
+
+import (
+	"math"
+	"math/rand"
+
+	ad "github.com/pbenner/autodiff"
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+// RMHMC denotes Riemannian manifold Hamiltonian Monte Carlo with the SoftAbs metric (Betancourt, 2013).
+//
+// The metric is mass * SoftAbs(Hessian of the potential energy), so the position-dependent metric
+// replaces the scalar mass of a particle. BMC keeps handing out Euclidean momenta p ~ N(0, mass I):
+// RMHMC maps them to N(0, G(x)) with the Cholesky factor of the metric at the start of a trajectory
+// and maps the final momentum back with the factor at the end, so collisions act on whitened momenta.
+type RMHMC struct {
+	StepSize ad.Scalar
+	NumSteps int
+	// FixedPointSteps is the number of fixed-point iterations of the implicit updates (default 6)
+	FixedPointSteps int
+	// SoftAbs is the sharpness alpha of the SoftAbs map, eigenvalues are bounded below by 1/alpha (default 1)
+	SoftAbs float64
+	// FiniteDifference is the step used to differentiate the metric (default 1e-4)
+	FiniteDifference float64
+	// Delta is the energy error above which a trajectory diverges (default 1000)
+	Delta float64
+}
+
+// riemannianMetric holds the metric and its derivatives at a position
+type riemannianMetric struct {
+	potential   float64
+	gradient    []float64
+	G, inverseG *mat.SymDense
+	cholesky    *mat.TriDense
+	logDet      float64
+	dG          []*mat.SymDense // dG[k] = dG/dx_k
+}
+
+// Sample samples from target distribution
+func (rmhmc RMHMC) Sample(
+	initialX, initialP ad.Vector,
+	mass ad.Scalar,
+	potentialEnergy logDistribution,
+	stepSize ad.Scalar,
+) (x, p ad.Vector, transition Transition) {
+	if stepSize.GetValue() != 0 {
+		rmhmc.StepSize = stepSize
+	}
+	if rmhmc.FixedPointSteps == 0 {
+		rmhmc.FixedPointSteps = 6
+	}
+	if rmhmc.SoftAbs == 0 {
+		rmhmc.SoftAbs = 1
+	}
+	if rmhmc.FiniteDifference == 0 {
+		rmhmc.FiniteDifference = 1e-4
+	}
+	if rmhmc.Delta == 0 {
+		rmhmc.Delta = 1e3
+	}
+	m := mass.GetValue()
+	eps := rmhmc.StepSize.GetValue()
+
+	position := initialX.GetValues()
+	dim := len(position)
+	// A metric that cannot be factorized counts as a divergence
+	diverged := func() (ad.Vector, ad.Vector, Transition) {
+		return initialX, initialP, Transition{
+			Acceptance:  ad.NewReal(math.NaN()),
+			Divergent:   true,
+			DivergenceX: Float64ToVector(append([]float64{}, position...)),
+		}
+	}
+	metric, ok := rmhmc.metric(position, m, potentialEnergy, true)
+	if !ok {
+		return diverged()
+	}
+	// p ~ N(0, m I) becomes p ~ N(0, G(x))
+	momentum := mulVec(metric.cholesky, initialP.GetValues())
+	floats.Scale(1/math.Sqrt(m), momentum)
+	H0 := riemannianHamiltonian(metric, momentum)
+	H := H0
+
+	for step := 0; step != rmhmc.NumSteps; step++ {
+		previousPosition := position
+		// implicit half step of momentum
+		half := append([]float64{}, momentum...)
+		for i := 0; i != rmhmc.FixedPointSteps; i++ {
+			half = floats.AddScaledTo(make([]float64, dim), momentum, -eps/2, dHdx(metric, half))
+		}
+		// implicit full step of position
+		velocity := mulVec(metric.inverseG, half)
+		next := floats.AddScaledTo(make([]float64, dim), position, eps, velocity)
+		for i := 0; i != rmhmc.FixedPointSteps; i++ {
+			nextMetric, ok := rmhmc.metric(next, m, potentialEnergy, false)
+			if !ok {
+				return diverged()
+			}
+			average := floats.AddTo(make([]float64, dim), mulVec(nextMetric.inverseG, half), velocity)
+			next = floats.AddScaledTo(make([]float64, dim), position, eps/2, average)
+		}
+		position = next
+		if metric, ok = rmhmc.metric(position, m, potentialEnergy, true); !ok {
+			return diverged()
+		}
+		// explicit half step of momentum
+		momentum = floats.AddScaledTo(make([]float64, dim), half, -eps/2, dHdx(metric, half))
+		H = riemannianHamiltonian(metric, momentum)
+		if energyError := H - H0; math.IsNaN(energyError) || energyError > rmhmc.Delta {
+			transition.Divergent = true
+			transition.DivergenceX = Float64ToVector(previousPosition)
+			break
+		}
+	}
+
+	deltaH := H - H0
+	if !transition.Divergent && -deltaH >= math.Log(1-rand.Float64()) {
+		// back to Euclidean momentum, flipped as in HMC
+		whitened := make([]float64, dim)
+		if err := mat.NewVecDense(dim, whitened).SolveVec(metric.cholesky, mat.NewVecDense(dim, momentum)); err != nil {
+			return diverged()
+		}
+		floats.Scale(-math.Sqrt(m), whitened)
+		x, p = Float64ToVector(position), Float64ToVector(whitened)
+		transition.Accepted = true
+	} else {
+		x, p = initialX, initialP
+	}
+	// NaN if the energy is not finite, which BMC counts as a divergence
+	transition.Acceptance = ad.NewReal(math.Min(1, math.Exp(-deltaH)))
+	return x, p, transition
+}
+
+// metric evaluates the SoftAbs metric at x. The derivatives of the metric need third
+// derivatives of the potential, which are taken by central differences of autodiff Hessians.
+func (rmhmc RMHMC) metric(x []float64, mass float64, potentialEnergy logDistribution, derivatives bool) (riemannianMetric, bool) {
+	potential, gradient, hessian := hessianOf(potentialEnergy, x)
+	metric := riemannianMetric{
+		potential: potential,
+		gradient:  gradient,
+	}
+	G, ok := softAbs(hessian, rmhmc.SoftAbs, mass)
+	if !ok {
+		return metric, false
+	}
+	metric.G = G
+	var chol mat.Cholesky
+	if !chol.Factorize(G) {
+		// SoftAbs is positive definite up to rounding, unless the Hessian is not finite
+		for i := range x {
+			G.SetSym(i, i, G.At(i, i)+1e-10)
+		}
+		if !chol.Factorize(G) {
+			return metric, false
+		}
+	}
+	metric.cholesky = mat.NewTriDense(len(x), mat.Lower, nil)
+	chol.LTo(metric.cholesky)
+	metric.inverseG = mat.NewSymDense(len(x), nil)
+	if err := chol.InverseTo(metric.inverseG); err != nil {
+		return metric, false
+	}
+	metric.logDet = chol.LogDet()
+	if derivatives {
+		h := rmhmc.FiniteDifference
+		metric.dG = make([]*mat.SymDense, len(x))
+		for k := range x {
+			xPlus, xMinus := append([]float64{}, x...), append([]float64{}, x...)
+			xPlus[k] += h
+			xMinus[k] -= h
+			_, _, hessianPlus := hessianOf(potentialEnergy, xPlus)
+			_, _, hessianMinus := hessianOf(potentialEnergy, xMinus)
+			GPlus, okPlus := softAbs(hessianPlus, rmhmc.SoftAbs, mass)
+			GMinus, okMinus := softAbs(hessianMinus, rmhmc.SoftAbs, mass)
+			if !okPlus || !okMinus {
+				return metric, false
+			}
+			metric.dG[k] = mat.NewSymDense(len(x), nil)
+			for i := range x {
+				for j := i; j != len(x); j++ {
+					metric.dG[k].SetSym(i, j, (GPlus.At(i, j)-GMinus.At(i, j))/(2*h))
+				}
+			}
+		}
+	}
+	return metric, true
+}
+
+// riemannianHamiltonian is U(x) + log det G(x) / 2 + p' G(x)^-1 p / 2
+func riemannianHamiltonian(metric riemannianMetric, p []float64) float64 {
+	v := mat.NewVecDense(len(p), p)
+	return metric.potential + 0.5*metric.logDet + 0.5*mat.Inner(v, metric.inverseG, v)
+}
+
+// dHdx is the gradient of the Riemannian Hamiltonian with respect to position
+func dHdx(metric riemannianMetric, p []float64) []float64 {
+	velocity := mat.NewVecDense(len(p), mulVec(metric.inverseG, p))
+	grad := make([]float64, len(p))
+	var product mat.Dense
+	for k := range grad {
+		product.Mul(metric.inverseG, metric.dG[k])
+		grad[k] = metric.gradient[k] + 0.5*mat.Trace(&product) - 0.5*mat.Inner(velocity, metric.dG[k], velocity)
+	}
+	return grad
+}
+
+// hessianOf evaluates a function with its gradient and Hessian by autodiff
+func hessianOf(f logDistribution, x []float64) (value float64, gradient []float64, hessian *mat.SymDense) {
+	v := Float64ToVector(append([]float64{}, x...))
+	v.Variables(2)
+	s := f(v)
+	gradient = make([]float64, len(x))
+	hessian = mat.NewSymDense(len(x), nil)
+	for i := range x {
+		gradient[i] = s.GetDerivative(i)
+		for j := i; j != len(x); j++ {
+			hessian.SetSym(i, j, s.GetHessian(i, j))
+		}
+	}
+	return s.GetValue(), gradient, hessian
+}
+
+// softAbs maps a symmetric matrix to mass * Q diag(lambda coth(alpha lambda)) Q'.
+// It fails if the eigendecomposition does, as for a matrix that is not finite.
+func softAbs(A mat.Symmetric, alpha, mass float64) (*mat.SymDense, bool) {
+	var eigen mat.EigenSym
+	if !eigen.Factorize(A, true) {
+		return nil, false
+	}
+	var Q mat.Dense
+	eigen.VectorsTo(&Q)
+	G := mat.NewSymDense(A.SymmetricDim(), nil)
+	for k, lambda := range eigen.Values(nil) {
+		var softLambda float64
+		if math.Abs(alpha*lambda) < 1e-8 {
+			softLambda = 1 / alpha
+		} else {
+			softLambda = lambda / math.Tanh(alpha*lambda)
+		}
+		G.SymRankOne(G, mass*softLambda, Q.ColView(k))
+	}
+	return G, true
+}
@@ -0,0 +1,196 @@
+package bmc
+
+import (
+	"context"
+	"time"
+
+	ad "github.com/pbenner/autodiff"
+)
+
+// Target is a probability density up to a constant, or its logarithm with LogDensity
+type Target = func(ad.Vector) ad.Scalar
+
+// Options configures Run
+type Options struct {
+	Sampler MCMC
+	// Collide defaults to NoCollision
+	Collide      Collision
+	NumParticles int
+	// NumDraws is the number of draws kept per particle, after NumWarmup iterations
+	NumDraws  int
+	NumWarmup int
+	// AdaptStepSize tunes the step size by dual averaging during warmup
+	AdaptStepSize bool
+	// Dim is the dimension of the target. InitialX defaults to the origin, or to the constrained
+	// image of the unconstrained origin with a Transform.
+	Dim      int
+	InitialX []float64
+	Radius   []float64
+	Masses   []float64
+	// Transform maps unconstrained to constrained parameters, nil means unconstrained
+	Transform Transform
+	// LogDensity means the target returns a log density, see BrownianMonteCarlo
+	LogDensity bool
+	// Names label the parameters, nil leaves them anonymous
+	Names []string
+	// GeneratedQuantities are computed for every kept draw, see BrownianMonteCarlo
+	GeneratedQuantities GeneratedQuantities
+	// GeneratedNames label the generated quantities, nil leaves them anonymous
+	GeneratedNames []string
+	// Workers are addresses of worker processes to run the transitions on, see ServeWorker
+	Workers []string
+	// Asynchronous steps the particles without a barrier between iterations, see BrownianMonteCarlo
+	Asynchronous bool
+}
+
+// Result holds the draws of Run with statistics and diagnostics
+type Result struct {
+	// Draws are indexed by [particle][draw][dim]
+	Draws [][][]float64
+	// Names label the dimensions of the draws, nil if they are anonymous
+	Names []string
+	// Generated are the generated quantities of the draws, indexed by [particle][draw][quantity],
+	// nil without GeneratedQuantities
+	Generated      [][][]float64
+	GeneratedNames []string
+
+	NumCollisions  []int
+	NumAccepted    []int
+	NumRejected    []int
+	NumEvaluations []int
+	NumDivergences []int
+	Diagnostics    Diagnostics
+	Elapsed        time.Duration
+}
+
+// Validate checks that the options describe a runnable sampler, errors are *ConfigError
+func (options Options) Validate() error {
+	switch {
+	case options.Sampler == nil:
+		return configErrorf("no sampler")
+	case options.NumParticles <= 0:
+		return configErrorf("number of particles must be positive, got %d", options.NumParticles)
+	case options.NumDraws <= 0:
+		return configErrorf("number of draws must be positive, got %d", options.NumDraws)
+	case options.NumWarmup < 0:
+		return configErrorf("number of warmup iterations must not be negative, got %d", options.NumWarmup)
+	case options.Dim <= 0:
+		return configErrorf("dimension must be positive, got %d", options.Dim)
+	case options.InitialX != nil && len(options.InitialX) != options.Dim:
+		return configErrorf("initial position has %d values, want %d", len(options.InitialX), options.Dim)
+	case len(options.Radius) != options.NumParticles:
+		return configErrorf("%d radii for %d particles", len(options.Radius), options.NumParticles)
+	case len(options.Masses) != options.NumParticles:
+		return configErrorf("%d masses for %d particles", len(options.Masses), options.NumParticles)
+	case options.Names != nil && len(options.Names) != options.Dim:
+		return configErrorf("%d names for %d parameters", len(options.Names), options.Dim)
+	case options.GeneratedNames != nil && options.GeneratedQuantities == nil:
+		return configErrorf("names for generated quantities without GeneratedQuantities")
+	case options.Transform != nil && options.Transform.ConstrainedDim() != options.Dim:
+		return configErrorf("transform has %d constrained parameters, want %d", options.Transform.ConstrainedDim(), options.Dim)
+	}
+	for i, mass := range options.Masses {
+		if !(mass > 0) {
+			return configErrorf("mass of particle %d must be positive, got %v", i, mass)
+		}
+	}
+	return nil
+}
+
+// Run samples a target with BMC and collects NumDraws draws of every particle.
+// If ctx is done or sampling fails first, Run stops and returns the draws so far
+// together with ctx.Err() or the sampling error.
+func Run(ctx context.Context, target Target, options Options) (*Result, error) {
+	if target == nil {
+		return nil, configErrorf("no target")
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	masses := make([]ad.Scalar, options.NumParticles)
+	for i, mass := range options.Masses {
+		masses[i] = ad.NewScalar(ad.RealType, mass)
+	}
+	radii := make([]float64, options.NumParticles)
+	copy(radii, options.Radius)
+	collide := options.Collide
+	if collide == nil {
+		collide = NoCollision
+	}
+	maxAdapt := 0
+	if options.AdaptStepSize {
+		maxAdapt = options.NumWarmup
+	}
+	bmc := BrownianMonteCarlo{
+		Sampler:      options.Sampler,
+		Collide:      collide,
+		NumParticles: options.NumParticles,
+		Radius:       radii,
+		Masses:       masses,
+		MaxAdapt:     maxAdapt,
+		NumWarmup:    options.NumWarmup,
+		Transform:    options.Transform,
+		Asynchronous: options.Asynchronous,
+		Workers:      options.Workers,
+		LogDensity:   options.LogDensity,
+		Names:        options.Names,
+
+		GeneratedQuantities: options.GeneratedQuantities,
+		GeneratedNames:      options.GeneratedNames,
+	}
+	initialX := options.InitialX
+	if initialX == nil && options.Transform != nil {
+		initialX = constrainValues(options.Transform, make([]float64, options.Transform.UnconstrainedDim()))
+	} else if initialX == nil {
+		initialX = make([]float64, options.Dim)
+	}
+
+	sample := make(chan Sample)
+	collidedSample := make(chan Sample, 1)
+	begin := time.Now()
+	err := bmc.Sample(target, ad.NewVector(ad.RealType, append([]float64{}, initialX...)), sample, collidedSample)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Draws: make([][][]float64, options.NumParticles), Names: options.Names}
+	if options.GeneratedQuantities != nil {
+		result.Generated = make([][][]float64, options.NumParticles)
+		result.GeneratedNames = options.GeneratedNames
+	}
+	for remaining := (options.NumWarmup + options.NumDraws) * options.NumParticles; remaining != 0; remaining-- {
+		var s Sample
+		ok := true
+		select {
+		case s, ok = <-sample:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if !ok {
+			err = bmc.Err()
+		}
+		if err != nil || !ok {
+			break
+		}
+		if s.Iteration >= options.NumWarmup {
+			result.Draws[s.ID] = append(result.Draws[s.ID], s.X)
+			if result.Generated != nil {
+				result.Generated[s.ID] = append(result.Generated[s.ID], s.Generated)
+			}
+		}
+	}
+	bmc.Stop()
+
+	result.Elapsed = time.Since(begin)
+	result.NumCollisions = bmc.NumCollisions
+	result.NumAccepted = bmc.NumAccepted
+	result.NumRejected = bmc.NumRejected
+	result.NumEvaluations = bmc.NumEvaluations
+	result.NumDivergences = bmc.NumDivergences
+	result.Diagnostics = NewDiagnostics(result.Draws, bmc.NumAccepted, bmc.NumRejected)
+	if result.Generated != nil {
+		result.Diagnostics.GeneratedRHat = RHat(result.Generated)
+		result.Diagnostics.GeneratedESS = EffectiveSampleSize(result.Generated)
+	}
+	return result, err
+}
@@ -0,0 +1,424 @@
+package bmc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ad "github.com/pbenner/autodiff"
+	ads "github.com/pbenner/autodiff/simple"
+)
+
+func validOptions() Options {
+	return Options{
+		Sampler:      HMC{StepSize: ad.NewReal(0.2), NumSteps: 5},
+		NumParticles: 2,
+		NumDraws:     10,
+		Dim:          2,
+		Radius:       []float64{1, 1},
+		Masses:       []float64{1, 2},
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	if err := validOptions().Validate(); err != nil {
+		t.Fatalf("valid options: %v", err)
+	}
+	for name, modify := range map[string]func(*Options){
+		"no sampler":       func(o *Options) { o.Sampler = nil },
+		"zero dimension":   func(o *Options) { o.Dim = 0 },
+		"zero draws":       func(o *Options) { o.NumDraws = 0 },
+		"radius length":    func(o *Options) { o.Radius = []float64{1} },
+		"masses length":    func(o *Options) { o.Masses = []float64{1, 2, 3} },
+		"negative mass":    func(o *Options) { o.Masses = []float64{1, -2} },
+		"initial position": func(o *Options) { o.InitialX = []float64{0} },
+		"transform":        func(o *Options) { o.Transform = StickBreakingTransform{K: 3} },
+		"generated names":  func(o *Options) { o.GeneratedNames = []string{"y"} },
+	} {
+		options := validOptions()
+		modify(&options)
+		if err := options.Validate(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestRunNilTarget(t *testing.T) {
+	if _, err := Run(context.Background(), nil, validOptions()); err == nil {
+		t.Error("nil target: no error")
+	}
+}
+
+func TestRun(t *testing.T) {
+	target := func(x ad.Vector) ad.Scalar { return ads.Exp(ads.Neg(standardNormal(x))) }
+	options := validOptions()
+	options.NumWarmup = 5
+	result, err := Run(context.Background(), target, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Draws) != 2 || len(result.Draws[0]) != 10 || len(result.Draws[1][9]) != 2 {
+		t.Fatalf("draws are not [2][10][2]")
+	}
+	for i, draws := range result.Draws {
+		if n := result.NumAccepted[i] + result.NumRejected[i]; n < options.NumWarmup+options.NumDraws {
+			t.Errorf("particle %d: %d transitions for %d iterations", i, n, options.NumWarmup+options.NumDraws)
+		}
+		// the energy error of 5 leapfrog steps of 0.2 on a Gaussian is small
+		if rate := result.Diagnostics.AcceptanceRate[i]; rate < 0.8 {
+			t.Errorf("particle %d: acceptance rate %v", i, rate)
+		}
+		if draws[0][0] == draws[len(draws)-1][0] {
+			t.Errorf("particle %d: the draws do not move", i)
+		}
+	}
+}
+
+func TestRunAsynchronous(t *testing.T) {
+	target := func(x ad.Vector) ad.Scalar { return ads.Exp(ads.Neg(standardNormal(x))) }
+	options := validOptions()
+	options.NumWarmup = 5
+	options.NumDraws = 100
+	options.Collide = NormalCollision
+	options.Asynchronous = true
+	// a fixed radius far beyond the spread of the target, so the particles collide whenever they approach
+	options.Radius = []float64{-10, -10}
+	result, err := Run(context.Background(), target, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Draws) != 2 || len(result.Draws[0]) != options.NumDraws || len(result.Draws[1][0]) != 2 {
+		t.Fatalf("draws are not [2][%d][2]", options.NumDraws)
+	}
+	for i, n := range result.NumCollisions {
+		if n == 0 {
+			t.Errorf("particle %d: no collisions in asynchronous mode", i)
+		}
+	}
+}
+
+// benchmarkSchedule runs NUTS on NumCPU particles, whose trees differ in depth on the anharmonic target
+func benchmarkSchedule(b *testing.B, asynchronous bool) {
+	target := func(x ad.Vector) ad.Scalar { return ads.Exp(ads.Neg(anharmonic(x))) }
+	numParticles := runtime.NumCPU()
+	options := Options{
+		Sampler:      NUTS{StepSize: ad.NewReal(0.05)},
+		Collide:      NormalCollision,
+		NumParticles: numParticles,
+		NumDraws:     b.N,
+		Dim:          2,
+		InitialX:     []float64{1, 1},
+		Radius:       make([]float64, numParticles),
+		Masses:       make([]float64, numParticles),
+		Asynchronous: asynchronous,
+	}
+	for i := range options.Masses {
+		options.Radius[i] = 1
+		options.Masses[i] = float64(i + 1)
+	}
+	b.ResetTimer()
+	if _, err := Run(context.Background(), target, options); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkSynchronous(b *testing.B) {
+	benchmarkSchedule(b, false)
+}
+
+func BenchmarkAsynchronous(b *testing.B) {
+	benchmarkSchedule(b, true)
+}
+
+func TestRunDistributed(t *testing.T) {
+	target := func(x ad.Vector) ad.Scalar { return ads.Exp(ads.Neg(standardNormal(x))) }
+	// a single particle consumes the global source in the same order in process and on a worker,
+	// so both runs make the same draws from the same seed
+	options := validOptions()
+	options.NumWarmup = 5
+	options.NumParticles = 1
+	options.Radius = []float64{1}
+	options.Masses = []float64{1}
+	rand.Seed(1)
+	local, err := Run(context.Background(), target, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go ServeWorker(listener, NewWorker(target, BrownianMonteCarlo{Sampler: options.Sampler}))
+	options.Workers = []string{listener.Addr().String()}
+	rand.Seed(1)
+	result, err := Run(context.Background(), target, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Draws) != 1 || len(result.Draws[0]) != 10 || len(result.Draws[0][9]) != 2 {
+		t.Fatalf("draws are not [1][10][2]")
+	}
+	if result.NumEvaluations[0] == 0 {
+		t.Error("no evaluations counted on the worker")
+	}
+	if result.NumAccepted[0] != local.NumAccepted[0] || result.NumRejected[0] != local.NumRejected[0] {
+		t.Errorf("accepted %d and rejected %d on the worker, %d and %d in process",
+			result.NumAccepted[0], result.NumRejected[0], local.NumAccepted[0], local.NumRejected[0])
+	}
+	for k, x := range result.Draws[0] {
+		assertClose(t, fmt.Sprintf("draw %d", k), x, local.Draws[0][k], 1e-12)
+	}
+}
+
+func TestRunUnreachableWorker(t *testing.T) {
+	target := func(x ad.Vector) ad.Scalar { return ads.Exp(ads.Neg(standardNormal(x))) }
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	options := validOptions()
+	options.Workers = []string{address}
+	_, err = Run(context.Background(), target, options)
+	if _, ok := err.(*WorkerError); !ok {
+		t.Errorf("unreachable worker: got %v, want *WorkerError", err)
+	}
+}
+
+func TestRunMismatchedWorker(t *testing.T) {
+	target := func(x ad.Vector) ad.Scalar { return ads.Exp(ads.Neg(standardNormal(x))) }
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go ServeWorker(listener, NewWorker(target, BrownianMonteCarlo{Sampler: HMC{StepSize: ad.NewReal(0.2), NumSteps: 3}}))
+	options := validOptions()
+	options.Workers = []string{listener.Addr().String()}
+	_, err = Run(context.Background(), target, options)
+	if _, ok := err.(*WorkerError); !ok {
+		t.Errorf("worker with another number of steps: got %v, want *WorkerError", err)
+	}
+}
+
+func TestSampleOrder(t *testing.T) {
+	target := func(x ad.Vector) ad.Scalar { return ads.Exp(ads.Neg(anharmonic(x))) }
+	for _, asynchronous := range []bool{false, true} {
+		numParticles := 4
+		bmc := BrownianMonteCarlo{
+			Sampler:      NUTS{StepSize: ad.NewReal(0.1)},
+			Collide:      NormalCollision,
+			NumParticles: numParticles,
+			Radius:       []float64{1, 1, 1, 1},
+			Masses:       []ad.Scalar{ad.NewReal(1), ad.NewReal(2), ad.NewReal(3), ad.NewReal(4)},
+			Asynchronous: asynchronous,
+		}
+		sample := make(chan Sample)
+		if err := bmc.Sample(target, ad.NewVector(ad.RealType, []float64{1, 1}), sample, make(chan Sample, 1)); err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n != 40; n++ {
+			s := <-sample
+			if s.ID != n%numParticles || s.Iteration != n/numParticles {
+				t.Errorf("asynchronous %v: sample %d is draw %d of particle %d", asynchronous, n, s.Iteration, s.ID)
+			}
+		}
+		bmc.Stop()
+	}
+}
+
+// slowSampler stays in place, taking a millisecond per transition of particles heavier than 1
+type slowSampler struct {
+	transitions []int64
+}
+
+func (slowSampler) MomentumFree() {}
+
+func (sampler slowSampler) Sample(x, p ad.Vector, mass ad.Scalar, potentialEnergy logDistribution, stepSize ad.Scalar) (ad.Vector, ad.Vector, Transition) {
+	id := int(mass.GetValue()) - 1
+	atomic.AddInt64(&sampler.transitions[id], 1)
+	if id > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	return x, p, Transition{Accepted: true, Acceptance: ad.NewReal(1)}
+}
+
+func TestAsynchronousWindow(t *testing.T) {
+	target := func(x ad.Vector) ad.Scalar { return ads.Exp(ads.Neg(standardNormal(x))) }
+	sampler := slowSampler{transitions: make([]int64, 2)}
+	bmc := BrownianMonteCarlo{
+		Sampler:      sampler,
+		Collide:      NoCollision,
+		NumParticles: 2,
+		Radius:       []float64{1, 1},
+		Masses:       []ad.Scalar{ad.NewReal(1), ad.NewReal(2)},
+		Asynchronous: true,
+		Window:       5,
+	}
+	sample := make(chan Sample)
+	if err := bmc.Sample(target, ad.NewVector(ad.RealType, []float64{0, 0}), sample, make(chan Sample, 1)); err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n != 20; n++ {
+		<-sample
+	}
+	// the fast particle waits for the draws of the slow one that are not forwarded
+	time.Sleep(50 * time.Millisecond)
+	fast, slow := atomic.LoadInt64(&sampler.transitions[0]), atomic.LoadInt64(&sampler.transitions[1])
+	bmc.Stop()
+	if fast > slow+int64(bmc.Window)+1 {
+		t.Errorf("fast particle ran %d transitions, the slow one %d with a window of %d", fast, slow, bmc.Window)
+	}
+}
+
+func TestSampleMetadata(t *testing.T) {
+	target := func(x ad.Vector) ad.Scalar { return ads.Exp(ads.Neg(anharmonic(x))) }
+	bmc := BrownianMonteCarlo{
+		Sampler:      NUTS{StepSize: ad.NewReal(0.1)},
+		Collide:      NormalCollision,
+		NumParticles: 2,
+		Radius:       []float64{1, 1},
+		Masses:       []ad.Scalar{ad.NewReal(1), ad.NewReal(2)},
+		KeepMomentum: true,
+	}
+	sample := make(chan Sample)
+	if err := bmc.Sample(target, ad.NewVector(ad.RealType, []float64{1, 1}), sample, make(chan Sample, 1)); err != nil {
+		t.Fatal(err)
+	}
+	maxDepth := 0
+	for n := 0; n != 20; n++ {
+		s := <-sample
+		if s.TreeDepth > maxDepth {
+			maxDepth = s.TreeDepth
+		}
+		if potential := anharmonic(Float64ToVector(s.X)).GetValue(); math.Abs(s.PotentialEnergy-potential) > 1e-9 {
+			t.Errorf("sample %d: potential energy %v, want %v", n, s.PotentialEnergy, potential)
+		}
+		if len(s.P) != 2 {
+			t.Fatalf("sample %d: momentum %v", n, s.P)
+		}
+		kinetic := kineticEnergy(Float64ToVector(s.P), bmc.Masses[s.ID]).GetValue()
+		if math.Abs(s.KineticEnergy-kinetic) > 1e-9 {
+			t.Errorf("sample %d: kinetic energy %v, want %v", n, s.KineticEnergy, kinetic)
+		}
+		if s.StepSize != 0.1 || s.Radius <= 0 || s.Warmup {
+			t.Errorf("sample %d: step size %v, radius %v, warmup %v", n, s.StepSize, s.Radius, s.Warmup)
+		}
+	}
+	bmc.Stop()
+	if maxDepth == 0 {
+		t.Errorf("no NUTS tree depth in the samples")
+	}
+}
+
+func TestRunGeneratedQuantities(t *testing.T) {
+	target := func(x ad.Vector) ad.Scalar { return ads.Exp(ads.Neg(standardNormal(x))) }
+	options := validOptions()
+	options.NumWarmup = 5
+	options.AdaptStepSize = true
+	options.GeneratedQuantities = func(x []float64, rng *rand.Rand) []float64 {
+		return []float64{x[0] * x[0], x[1] + rng.NormFloat64()}
+	}
+	options.GeneratedNames = []string{"x1squared", "y"}
+	result, err := Run(context.Background(), target, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, generated := range result.Generated {
+		if len(generated) != options.NumDraws {
+			t.Fatalf("particle %d: %d generated draws, want %d", i, len(generated), options.NumDraws)
+		}
+		for k, g := range generated {
+			if x := result.Draws[i][k]; len(g) != 2 || g[0] != x[0]*x[0] {
+				t.Errorf("particle %d, draw %d: generated %v for %v", i, k, g, x)
+			}
+		}
+	}
+	if len(result.Diagnostics.GeneratedRHat) != 2 || len(result.Diagnostics.GeneratedESS) != 2 {
+		t.Errorf("diagnostics of generated quantities: %+v", result.Diagnostics)
+	}
+
+	options.GeneratedNames = []string{"x1squared"}
+	if _, err := Run(context.Background(), target, options); err == nil {
+		t.Error("too many generated quantities: no error")
+	}
+}
+
+func TestWarmupWithoutAdaptation(t *testing.T) {
+	target := func(x ad.Vector) ad.Scalar { return ads.Exp(ads.Neg(standardNormal(x))) }
+	var calls int64
+	bmc := BrownianMonteCarlo{
+		Sampler:      HMC{StepSize: ad.NewReal(0.2), NumSteps: 5},
+		Collide:      NoCollision,
+		NumParticles: 2,
+		Radius:       []float64{1, 1},
+		Masses:       []ad.Scalar{ad.NewReal(1), ad.NewReal(2)},
+		NumWarmup:    5,
+		GeneratedQuantities: func(x []float64, rng *rand.Rand) []float64 {
+			atomic.AddInt64(&calls, 1)
+			return []float64{x[0]}
+		},
+	}
+	sample := make(chan Sample)
+	if err := bmc.Sample(target, ad.NewVector(ad.RealType, []float64{0, 0}), sample, make(chan Sample, 1)); err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n != 20; n++ {
+		s := <-sample
+		if warmup := s.Iteration < bmc.NumWarmup; s.Warmup != warmup || (s.Generated == nil) != warmup {
+			t.Errorf("iteration %d: warmup %v, generated %v", s.Iteration, s.Warmup, s.Generated)
+		}
+	}
+	bmc.Stop()
+	if n := atomic.LoadInt64(&calls); n < 10 {
+		t.Errorf("%d generated quantities after warmup, want at least 10", n)
+	}
+
+	bmc.NumWarmup = -1
+	if err := bmc.Sample(target, ad.NewVector(ad.RealType, []float64{0, 0}), sample, make(chan Sample, 1)); err == nil {
+		t.Error("negative warmup: no error")
+	}
+}
+
+func TestRunTransformDefaultInitialX(t *testing.T) {
+	for name, c := range map[string]struct {
+		transform Transform
+		target    Target
+	}{
+		"Log": {
+			transform: LogTransform{Dim: 2},
+			// independent exponentials
+			target: func(x ad.Vector) ad.Scalar {
+				return ads.Exp(ads.Neg(ads.VdotV(ad.NewVector(ad.RealType, []float64{1, 1}), x)))
+			},
+		},
+		"StickBreaking": {
+			transform: StickBreakingTransform{K: 2},
+			// flat on the simplex
+			target: func(x ad.Vector) ad.Scalar { return ads.Exp(ads.Mul(ad.NewReal(0), ads.VdotV(x, x))) },
+		},
+	} {
+		options := validOptions()
+		options.Transform = c.transform
+		result, err := Run(context.Background(), c.target, options)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		for i, draws := range result.Draws {
+			for k, x := range draws {
+				if !(x[0] > 0 && x[1] > 0) {
+					t.Errorf("%s: particle %d, draw %d: %v is not positive", name, i, k, x)
+				}
+			}
+		}
+	}
+}
@@ -15,15 +15,30 @@ type MCMC interface {
 		mass ad.Scalar,
 		potentialEnergy logDistribution,
 		stepSize ad.Scalar,
-	) (x, p ad.Vector, accepted bool, acceptance ad.Scalar)
+	) (x, p ad.Vector, transition Transition)
 	// getStepSize() ad.Scalar
 	// setStepSize(newStepSize ad.Scalar)
 }
 
+// Transition describes a transition of an MCMC sampler
+type Transition struct {
+	Accepted   bool
+	Acceptance ad.Scalar
+	// Divergent is set if the energy error exceeded the threshold of the sampler or was not finite
+	Divergent bool
+	// DivergenceX is the last position before the energy error exceeded the threshold
+	DivergenceX ad.Vector
+	// TreeDepth is the depth of the trajectory tree of NUTS, 0 for other samplers
+	TreeDepth int
+}
+
 // HMC denotes Hamiltonian Monte Carlo sampler
 type HMC struct {
-	StepSize ad.Scalar
-	NumSteps int
+	StepSize   ad.Scalar
+	NumSteps   int
+	Integrator Integrator
+	// Delta is the energy error above which a trajectory diverges (default 1000)
+	Delta float64
 }
 
 // Sample function smaple from target distribution
@@ -32,39 +47,55 @@ func (hmc HMC) Sample(
 	mass ad.Scalar,
 	potentialEnergy logDistribution,
 	stepSize ad.Scalar,
-) (x, p ad.Vector, accepted bool, acceptance ad.Scalar) {
+) (x, p ad.Vector, transition Transition) {
 	if stepSize.GetValue() != 0 {
 		hmc.StepSize = stepSize
 	}
+	if hmc.Delta == 0 {
+		hmc.Delta = 1e3
+	}
+	integrator := integratorOf(hmc)
 	H0 := hamiltonian(initialX, initialP, mass, potentialEnergy)
+	H := H0
 	x, p = clone(initialX), clone(initialP)
 	for i := 0; i != hmc.NumSteps; i++ {
-		x, p = leapfrog(x, p, hmc.StepSize, potentialEnergy, mass)
+		previousX := x
+		x, p = integrator.Integrate(x, p, hmc.StepSize, potentialEnergy, mass)
+		H = hamiltonian(x, p, mass, potentialEnergy)
+		if energyError := H.GetValue() - H0.GetValue(); math.IsNaN(energyError) || energyError > hmc.Delta {
+			transition.Divergent = true
+			transition.DivergenceX = previousX
+			break
+		}
 	}
-	H := hamiltonian(x, p, mass, potentialEnergy)
 
 	deltaH := ads.Sub(H, H0)
-	if deltaH.GetValue() >= math.Log(1-rand.Float64()) {
+	if !transition.Divergent && -deltaH.GetValue() >= math.Log(1-rand.Float64()) {
 		p = ads.VmulS(p, ad.NewReal(-1))
-		accepted = true
+		transition.Accepted = true
 	} else {
 		x = initialX
 		p = initialP
-		accepted = false
+		transition.Accepted = false
 	}
-	acceptance = ads.Min(ad.NewReal(1), ads.Exp(ads.Neg(deltaH)))
-	return x, p, accepted, acceptance
+	transition.Acceptance = ads.Min(ad.NewReal(1), ads.Exp(ads.Neg(deltaH)))
+	return x, p, transition
 }
 
 // NUTS denotes No-U-Turn Sampler
 type NUTS struct {
 	MaxDepth int
 	StepSize ad.Scalar
-	Delta    float64
-	Depth    [][2]float64
+	// Delta is the energy error above which the tree stops as divergent (default 1000)
+	Delta      float64
+	Depth      [][2]float64
+	Integrator Integrator
 
 	mass            ad.Scalar
 	potentialEnergy logDistribution
+	integrator      Integrator
+	// divergenceX is shared by the copies of nuts made while building one tree
+	divergenceX *ad.Vector
 }
 
 // Sample samples from target distribution
@@ -73,12 +104,16 @@ func (nuts NUTS) Sample(
 	mass ad.Scalar,
 	potentialEnergy logDistribution,
 	stepSize ad.Scalar,
-) (x, p ad.Vector, accepted bool, acceptance ad.Scalar) {
+) (x, p ad.Vector, transition Transition) {
 	// Set defaults
 	nuts.potentialEnergy = potentialEnergy
-	nuts.Delta = 1e3
+	if nuts.Delta == 0 {
+		nuts.Delta = 1e3
+	}
+	nuts.divergenceX = new(ad.Vector)
 	nuts.mass = mass
 	nuts.MaxDepth = 5
+	nuts.integrator = integratorOf(nuts)
 	if stepSize.GetValue() != 0 {
 		nuts.StepSize = stepSize
 	}
@@ -91,11 +126,10 @@ func (nuts NUTS) Sample(
 
 	H0 := hamiltonian(x, p, mass, potentialEnergy)
 	// Sample the slice variable
-	logu := ads.Add(ad.NewReal(math.Log(1-rand.Float64())), H0)
+	logu := ads.Sub(ad.NewReal(math.Log(1-rand.Float64())), H0)
 
 	// Initialize the tree
 	xl, pl, xr, pr, depth, nelem := clone(x), clone(p), clone(x), clone(p), 0, 1.
-	accepted = false
 	// Integrate forward
 	for {
 		// Choose direction
@@ -117,7 +151,7 @@ func (nuts NUTS) Sample(
 
 		// Accept or reject
 		if nelemPrime/nelem > rand.Float64() {
-			accepted = true
+			transition.Accepted = true
 			x = xPrime
 			p = pPrime
 		}
@@ -131,8 +165,13 @@ func (nuts NUTS) Sample(
 		}
 	}
 	nuts.updateDepth(depth)
-	acceptance = ads.Div(alpha, ad.NewReal(float64(nAlpha)))
-	return x, p, accepted, acceptance
+	transition.TreeDepth = depth
+	transition.Acceptance = ads.Div(alpha, ad.NewReal(float64(nAlpha)))
+	if *nuts.divergenceX != nil {
+		transition.Divergent = true
+		transition.DivergenceX = *nuts.divergenceX
+	}
+	return x, p, transition
 }
 
 func (nuts NUTS) buildLeftOrRightTree(
@@ -161,14 +200,18 @@ func (nuts NUTS) buildTree(
 ) (xl, pl, xr, pr, _, _ ad.Vector, nelem float64, stop bool, alpha ad.Scalar, nAlpha int) {
 	if depth == 0 {
 		// Base case: single leapfrog
+		previousX := x
 		x, p := clone(x), clone(p)
-		x, p = leapfrog(x, p, ads.Mul(ad.NewReal(dir), nuts.StepSize), nuts.potentialEnergy, nuts.mass)
+		x, p = nuts.integrator.Integrate(x, p, ads.Mul(ad.NewReal(dir), nuts.StepSize), nuts.potentialEnergy, nuts.mass)
 		H1 := hamiltonian(x, p, nuts.mass, nuts.potentialEnergy)
-		if H1.GetValue() >= logu.GetValue() {
+		if -H1.GetValue() >= logu.GetValue() {
 			nelem = 1
 		}
-		if H1.GetValue()+nuts.Delta <= logu.GetValue() {
+		if -H1.GetValue()+nuts.Delta <= logu.GetValue() || math.IsNaN(H1.GetValue()) {
 			stop = true
+			if *nuts.divergenceX == nil {
+				*nuts.divergenceX = previousX
+			}
 		}
 		H0 := hamiltonian(initialX, initialP, nuts.mass, nuts.potentialEnergy)
 		alpha = ads.Min(ad.NewReal(1), ads.Exp(ads.Sub(H0, H1)))
@@ -216,3 +259,28 @@ func (nuts NUTS) getStepSize() ad.Scalar {
 func (nuts NUTS) setStepSize(newStepSize ad.Scalar) {
 	nuts.StepSize = newStepSize
 }
+
+// stepSizeOf returns the step size a sampler is configured with, the initial bracket width of Slice
+func stepSizeOf(sampler MCMC) float64 {
+	var stepSize ad.Scalar
+	switch s := sampler.(type) {
+	case HMC:
+		stepSize = s.StepSize
+	case NUTS:
+		stepSize = s.StepSize
+	case RMHMC:
+		stepSize = s.StepSize
+	case MALA:
+		stepSize = s.StepSize
+	case RWM:
+		stepSize = s.StepSize
+	case SGHMC:
+		stepSize = s.StepSize
+	case Slice:
+		return s.Width
+	}
+	if stepSize == nil {
+		return 0
+	}
+	return stepSize.GetValue()
+}
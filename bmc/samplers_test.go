@@ -0,0 +1,110 @@
+package bmc
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	ad "github.com/pbenner/autodiff"
+)
+
+// ksStandardNormal returns the Kolmogorov-Smirnov statistic of xs against N(0, 1)
+func ksStandardNormal(xs []float64) float64 {
+	sorted := append([]float64{}, xs...)
+	sort.Float64s(sorted)
+	n := float64(len(sorted))
+	D := 0.
+	for i, x := range sorted {
+		cdf := 0.5 * math.Erfc(-x/math.Sqrt2)
+		D = math.Max(D, math.Max(float64(i+1)/n-cdf, cdf-float64(i)/n))
+	}
+	return D
+}
+
+// checkInvariance starts a chain at an exact draw from a standard Gaussian
+// and checks that every marginal still follows the Gaussian after many transitions.
+func checkInvariance(t *testing.T, sampler MCMC, stepSize float64) {
+	if testing.Short() {
+		t.Skip("long run")
+	}
+	rand.Seed(1)
+	const dim, numSamples, thin = 2, 2000, 5
+	mass := ad.NewReal(1)
+	x := sampleMomentum(dim, mass)
+	draws := make([][]float64, dim)
+	for i := 0; i != numSamples*thin; i++ {
+		p := sampleMomentum(dim, mass)
+		x, _, _ = sampler.Sample(x, p, mass, standardNormal, ad.NewReal(stepSize))
+		if i%thin == 0 {
+			for d, v := range x.GetValues() {
+				draws[d] = append(draws[d], v)
+			}
+		}
+	}
+	// critical value at the 0.1% level
+	critical := 1.95 / math.Sqrt(numSamples)
+	for d := range draws {
+		if D := ksStandardNormal(draws[d]); D > critical {
+			t.Errorf("dim %d: KS statistic %v > %v", d, D, critical)
+		}
+	}
+}
+
+func TestHMCInvariance(t *testing.T) {
+	checkInvariance(t, HMC{StepSize: ad.NewReal(0.2), NumSteps: 10}, 0.2)
+}
+
+func TestNUTSInvariance(t *testing.T) {
+	checkInvariance(t, NUTS{StepSize: ad.NewReal(0.2)}, 0.2)
+}
+
+func TestMALAInvariance(t *testing.T) {
+	checkInvariance(t, MALA{StepSize: ad.NewReal(0.8)}, 0.8)
+}
+
+func TestRWMInvariance(t *testing.T) {
+	checkInvariance(t, NewRWM(ad.NewReal(1.5), 200), 1.5)
+}
+
+func TestSliceInvariance(t *testing.T) {
+	checkInvariance(t, Slice{Width: 1}, 0)
+}
+
+func TestRMHMCInvariance(t *testing.T) {
+	checkInvariance(t, RMHMC{StepSize: ad.NewReal(0.3), NumSteps: 5}, 0.3)
+}
+
+func TestSGHMCInvariance(t *testing.T) {
+	checkInvariance(t, SGHMC{StepSize: ad.NewReal(0.05), NumSteps: 20}, 0.05)
+}
+
+func TestDivergence(t *testing.T) {
+	rand.Seed(1)
+	mass := ad.NewReal(1)
+	x := Float64ToVector([]float64{1, 1})
+	samplers := []MCMC{
+		HMC{StepSize: ad.NewReal(10), NumSteps: 10},
+		NUTS{StepSize: ad.NewReal(10)},
+		RMHMC{StepSize: ad.NewReal(10), NumSteps: 5},
+	}
+	for _, sampler := range samplers {
+		xNew, _, transition := sampler.Sample(x, sampleMomentum(2, mass), mass, anharmonic, ad.NewReal(10))
+		if !transition.Divergent || transition.DivergenceX == nil {
+			t.Errorf("%T: transition with step size 10 did not diverge", sampler)
+		}
+		if transition.Divergent && transition.Accepted {
+			t.Errorf("%T: divergent transition was accepted", sampler)
+		}
+		if xNew.GetValues()[0] != 1 || xNew.GetValues()[1] != 1 {
+			t.Errorf("%T: divergent transition moved to %v", sampler, xNew.GetValues())
+		}
+	}
+	// a tiny step size never diverges
+	_, _, transition := HMC{StepSize: ad.NewReal(0.01), NumSteps: 10}.Sample(
+		x, sampleMomentum(2, mass), mass, anharmonic, ad.NewReal(0.01),
+	)
+	if transition.Divergent {
+		t.Errorf("HMC: transition with step size 0.01 diverged")
+	}
+}
@@ -0,0 +1,308 @@
+package bmc
+
+import (
+	"math"
+
+	ad "github.com/pbenner/autodiff"
+	ads "github.com/pbenner/autodiff/simple"
+)
+
+// Transform maps unconstrained parameters y in R^n to constrained parameters x.
+// BMC samples in the unconstrained space and reports draws in the constrained space.
+type Transform interface {
+	UnconstrainedDim() int
+	ConstrainedDim() int
+	// Constrain returns x = T(y) and log |det dT/dy|
+	Constrain(y ad.Vector) (x ad.Vector, logJacobian ad.Scalar)
+	// Unconstrain returns y = T^-1(x)
+	Unconstrain(x []float64) []float64
+}
+
+// IdentityTransform leaves parameters unconstrained
+type IdentityTransform struct {
+	Dim int
+}
+
+// UnconstrainedDim returns the number of unconstrained parameters
+func (t IdentityTransform) UnconstrainedDim() int { return t.Dim }
+
+// ConstrainedDim returns the number of constrained parameters
+func (t IdentityTransform) ConstrainedDim() int { return t.Dim }
+
+// Constrain maps unconstrained parameters to constrained ones
+func (t IdentityTransform) Constrain(y ad.Vector) (ad.Vector, ad.Scalar) {
+	return y, ad.NewScalar(ad.RealType, 0)
+}
+
+// Unconstrain maps constrained parameters to unconstrained ones
+func (t IdentityTransform) Unconstrain(x []float64) []float64 {
+	return append([]float64{}, x...)
+}
+
+// LogTransform constrains parameters to (Lower, inf) by x = Lower + exp(y)
+type LogTransform struct {
+	Dim   int
+	Lower float64
+}
+
+// UnconstrainedDim returns the number of unconstrained parameters
+func (t LogTransform) UnconstrainedDim() int { return t.Dim }
+
+// ConstrainedDim returns the number of constrained parameters
+func (t LogTransform) ConstrainedDim() int { return t.Dim }
+
+// Constrain maps unconstrained parameters to constrained ones
+func (t LogTransform) Constrain(y ad.Vector) (ad.Vector, ad.Scalar) {
+	xs := make([]ad.Scalar, t.Dim)
+	logJacobian := ad.NewScalar(ad.RealType, 0)
+	for i, yi := range Components(y) {
+		xs[i] = ads.Add(ad.NewReal(t.Lower), ads.Exp(yi))
+		logJacobian = ads.Add(logJacobian, yi)
+	}
+	return assemble(xs), logJacobian
+}
+
+// Unconstrain maps constrained parameters to unconstrained ones
+func (t LogTransform) Unconstrain(x []float64) []float64 {
+	y := make([]float64, len(x))
+	for i, xi := range x {
+		y[i] = math.Log(xi - t.Lower)
+	}
+	return y
+}
+
+// LogitTransform constrains parameters to (Lower, Upper) by x = Lower + (Upper - Lower) logistic(y)
+type LogitTransform struct {
+	Dim          int
+	Lower, Upper float64
+}
+
+// UnconstrainedDim returns the number of unconstrained parameters
+func (t LogitTransform) UnconstrainedDim() int { return t.Dim }
+
+// ConstrainedDim returns the number of constrained parameters
+func (t LogitTransform) ConstrainedDim() int { return t.Dim }
+
+// Constrain maps unconstrained parameters to constrained ones
+func (t LogitTransform) Constrain(y ad.Vector) (ad.Vector, ad.Scalar) {
+	xs := make([]ad.Scalar, t.Dim)
+	width := t.Upper - t.Lower
+	logJacobian := ad.NewScalar(ad.RealType, float64(t.Dim)*math.Log(width))
+	for i, yi := range Components(y) {
+		xs[i] = ads.Add(ad.NewReal(t.Lower), ads.Mul(ad.NewReal(width), logistic(yi)))
+		logJacobian = ads.Add(logJacobian, ads.Add(logLogistic(yi), logLogistic(ads.Neg(yi))))
+	}
+	return assemble(xs), logJacobian
+}
+
+// Unconstrain maps constrained parameters to unconstrained ones
+func (t LogitTransform) Unconstrain(x []float64) []float64 {
+	y := make([]float64, len(x))
+	for i, xi := range x {
+		u := (xi - t.Lower) / (t.Upper - t.Lower)
+		y[i] = math.Log(u / (1 - u))
+	}
+	return y
+}
+
+// StickBreakingTransform maps K-1 unconstrained parameters to a K-simplex
+type StickBreakingTransform struct {
+	K int
+}
+
+// UnconstrainedDim returns the number of unconstrained parameters
+func (t StickBreakingTransform) UnconstrainedDim() int { return t.K - 1 }
+
+// ConstrainedDim returns the number of constrained parameters
+func (t StickBreakingTransform) ConstrainedDim() int { return t.K }
+
+// Constrain maps unconstrained parameters to constrained ones
+func (t StickBreakingTransform) Constrain(y ad.Vector) (ad.Vector, ad.Scalar) {
+	xs := make([]ad.Scalar, t.K)
+	logJacobian := ad.NewScalar(ad.RealType, 0)
+	stick := ad.NewScalar(ad.RealType, 1)
+	for k, yk := range Components(y) {
+		// the offset makes y = 0 the uniform simplex
+		u := ads.Sub(yk, ad.NewReal(math.Log(float64(t.K-1-k))))
+		z := logistic(u)
+		xs[k] = ads.Mul(stick, z)
+		logJacobian = ads.Add(logJacobian, ads.Add(ads.Add(logLogistic(u), logLogistic(ads.Neg(u))), ads.Log(stick)))
+		stick = ads.Sub(stick, xs[k])
+	}
+	xs[t.K-1] = stick
+	return assemble(xs), logJacobian
+}
+
+// Unconstrain maps constrained parameters to unconstrained ones
+func (t StickBreakingTransform) Unconstrain(x []float64) []float64 {
+	y := make([]float64, t.K-1)
+	stick := 1.
+	for k := range y {
+		z := x[k] / stick
+		y[k] = math.Log(z/(1-z)) + math.Log(float64(t.K-1-k))
+		stick -= x[k]
+	}
+	return y
+}
+
+// CholeskyCorrTransform maps K(K-1)/2 unconstrained parameters to the Cholesky factor
+// of a K x K correlation matrix, flattened row by row into K*K values
+type CholeskyCorrTransform struct {
+	K int
+}
+
+// UnconstrainedDim returns the number of unconstrained parameters
+func (t CholeskyCorrTransform) UnconstrainedDim() int { return t.K * (t.K - 1) / 2 }
+
+// ConstrainedDim returns the number of constrained parameters
+func (t CholeskyCorrTransform) ConstrainedDim() int { return t.K * t.K }
+
+// Constrain maps unconstrained parameters to constrained ones
+func (t CholeskyCorrTransform) Constrain(y ad.Vector) (ad.Vector, ad.Scalar) {
+	ys := Components(y)
+	L := make([]ad.Scalar, t.K*t.K)
+	logJacobian := ad.NewScalar(ad.RealType, 0)
+	one := ad.NewReal(1)
+	L[0] = ad.NewScalar(ad.RealType, 1)
+	index := 0
+	for i := 1; i != t.K; i++ {
+		sumOfSquares := ad.NewScalar(ad.RealType, 0)
+		for j := 0; j != i; j++ {
+			// canonical partial correlation in (-1, 1)
+			z := tanh(ys[index])
+			index++
+			logJacobian = ads.Add(logJacobian, ads.Log(ads.Sub(one, ads.Mul(z, z))))
+			if j == 0 {
+				L[i*t.K+j] = z
+			} else {
+				rest := ads.Sub(one, sumOfSquares)
+				L[i*t.K+j] = ads.Mul(z, ads.Sqrt(rest))
+				logJacobian = ads.Add(logJacobian, ads.Mul(ad.NewReal(0.5), ads.Log(rest)))
+			}
+			sumOfSquares = ads.Add(sumOfSquares, ads.Mul(L[i*t.K+j], L[i*t.K+j]))
+		}
+		L[i*t.K+i] = ads.Sqrt(ads.Sub(one, sumOfSquares))
+	}
+	return assemble(L), logJacobian
+}
+
+// Unconstrain maps constrained parameters to unconstrained ones
+func (t CholeskyCorrTransform) Unconstrain(x []float64) []float64 {
+	y := make([]float64, 0, t.UnconstrainedDim())
+	for i := 1; i != t.K; i++ {
+		sumOfSquares := 0.
+		for j := 0; j != i; j++ {
+			Lij := x[i*t.K+j]
+			y = append(y, math.Atanh(Lij/math.Sqrt(1-sumOfSquares)))
+			sumOfSquares += Lij * Lij
+		}
+	}
+	return y
+}
+
+// Transforms concatenates transforms of consecutive blocks of parameters
+type Transforms []Transform
+
+// UnconstrainedDim returns the number of unconstrained parameters
+func (ts Transforms) UnconstrainedDim() int {
+	dim := 0
+	for _, t := range ts {
+		dim += t.UnconstrainedDim()
+	}
+	return dim
+}
+
+// ConstrainedDim returns the number of constrained parameters
+func (ts Transforms) ConstrainedDim() int {
+	dim := 0
+	for _, t := range ts {
+		dim += t.ConstrainedDim()
+	}
+	return dim
+}
+
+// Constrain maps unconstrained parameters to constrained ones
+func (ts Transforms) Constrain(y ad.Vector) (ad.Vector, ad.Scalar) {
+	ys := Components(y)
+	xs := make([]ad.Scalar, 0, ts.ConstrainedDim())
+	logJacobian := ad.NewScalar(ad.RealType, 0)
+	offset := 0
+	for _, t := range ts {
+		n := t.UnconstrainedDim()
+		x, blockLogJacobian := t.Constrain(assemble(ys[offset : offset+n]))
+		offset += n
+		xs = append(xs, Components(x)...)
+		logJacobian = ads.Add(logJacobian, blockLogJacobian)
+	}
+	return assemble(xs), logJacobian
+}
+
+// Unconstrain maps constrained parameters to unconstrained ones
+func (ts Transforms) Unconstrain(x []float64) []float64 {
+	y := make([]float64, 0, ts.UnconstrainedDim())
+	offset := 0
+	for _, t := range ts {
+		n := t.ConstrainedDim()
+		y = append(y, t.Unconstrain(x[offset:offset+n])...)
+		offset += n
+	}
+	return y
+}
+
+// transformedPotential is the potential energy of the unconstrained parameters,
+// including the log Jacobian of the transform
+func transformedPotential(potentialEnergy logDistribution, transform Transform) logDistribution {
+	return func(y ad.Vector) ad.Scalar {
+		x, logJacobian := transform.Constrain(y)
+		return ads.Sub(potentialEnergy(x), logJacobian)
+	}
+}
+
+// constrainValues maps unconstrained values to constrained ones without derivatives
+func constrainValues(transform Transform, y []float64) []float64 {
+	x, _ := transform.Constrain(Float64ToVector(append([]float64{}, y...)))
+	return x.GetValues()
+}
+
+// Components splits a vector into scalars while keeping derivatives, so that a target
+// or transform can work on single parameters
+func Components(v ad.Vector) []ad.Scalar {
+	scalars := make([]ad.Scalar, v.Dim())
+	for i := range scalars {
+		scalars[i] = ads.VdotV(unitVector(v.Dim(), i), v)
+	}
+	return scalars
+}
+
+// assemble builds a vector from scalars while keeping derivatives, nil scalars are zeros
+func assemble(scalars []ad.Scalar) ad.Vector {
+	v := ad.NewVector(ad.RealType, make([]float64, len(scalars)))
+	for i, s := range scalars {
+		if s != nil {
+			v = ads.VaddV(v, ads.VmulS(unitVector(len(scalars), i), s))
+		}
+	}
+	return v
+}
+
+func unitVector(dim, i int) ad.Vector {
+	e := make([]float64, dim)
+	e[i] = 1
+	return ad.NewVector(ad.RealType, e)
+}
+
+func logistic(y ad.Scalar) ad.Scalar {
+	one := ad.NewReal(1)
+	return ads.Div(one, ads.Add(one, ads.Exp(ads.Neg(y))))
+}
+
+// logLogistic is log(logistic(y)) = -log(1 + exp(-y))
+func logLogistic(y ad.Scalar) ad.Scalar {
+	return ads.Neg(ads.Log(ads.Add(ad.NewReal(1), ads.Exp(ads.Neg(y)))))
+}
+
+func tanh(y ad.Scalar) ad.Scalar {
+	one := ad.NewReal(1)
+	e := ads.Exp(ads.Mul(ad.NewReal(2), y))
+	return ads.Div(ads.Sub(e, one), ads.Add(e, one))
+}
@@ -0,0 +1,68 @@
+package bmc
+
+import (
+	"math"
+	"testing"
+)
+
+var testTransforms = map[string]Transform{
+	"Log":           LogTransform{Dim: 2, Lower: 1},
+	"Logit":         LogitTransform{Dim: 2, Lower: -1, Upper: 3},
+	"StickBreaking": StickBreakingTransform{K: 3},
+	"CholeskyCorr":  CholeskyCorrTransform{K: 3},
+	"Transforms":    Transforms{IdentityTransform{Dim: 1}, LogTransform{Dim: 1}, StickBreakingTransform{K: 3}},
+}
+
+func TestTransformsRoundTrip(t *testing.T) {
+	for name, transform := range testTransforms {
+		y := make([]float64, transform.UnconstrainedDim())
+		for i := range y {
+			y[i] = 0.3*float64(i) - 0.4
+		}
+		x := constrainValues(transform, y)
+		if len(x) != transform.ConstrainedDim() {
+			t.Fatalf("%s: constrained dim %d, want %d", name, len(x), transform.ConstrainedDim())
+		}
+		assertClose(t, name, transform.Unconstrain(x), y, 1e-9)
+	}
+}
+
+func TestTransformsConstraints(t *testing.T) {
+	y := []float64{-2.5, 3.1}
+	simplex := constrainValues(StickBreakingTransform{K: 3}, y)
+	if sum := simplex[0] + simplex[1] + simplex[2]; math.Abs(sum-1) > 1e-12 {
+		t.Errorf("simplex sums to %v", sum)
+	}
+	L := constrainValues(CholeskyCorrTransform{K: 3}, []float64{0.5, -1.2, 2})
+	for i := 0; i != 3; i++ {
+		// rows of the Cholesky factor of a correlation matrix have unit length
+		norm := 0.
+		for j := 0; j != 3; j++ {
+			norm += L[i*3+j] * L[i*3+j]
+		}
+		if math.Abs(norm-1) > 1e-12 {
+			t.Errorf("row %d has squared norm %v", i, norm)
+		}
+	}
+}
+
+func TestTransformsLogJacobian(t *testing.T) {
+	// Square transforms, so log |det J| can be checked by finite differences
+	for _, name := range []string{"Log", "Logit"} {
+		transform := testTransforms[name]
+		y := []float64{0.2, -0.7}
+		_, logJacobian := transform.Constrain(Float64ToVector(append([]float64{}, y...)))
+		J := jacobian(func(y []float64) []float64 { return constrainValues(transform, y) }, y, 1e-6)
+		if want := math.Log(math.Abs(determinant(J))); math.Abs(logJacobian.GetValue()-want) > 1e-6 {
+			t.Errorf("%s: log Jacobian %v, want %v", name, logJacobian.GetValue(), want)
+		}
+	}
+	// Stick breaking is square in its first K-1 coordinates
+	y := []float64{0.2, -0.7}
+	stickBreaking := StickBreakingTransform{K: 3}
+	_, logJacobian := stickBreaking.Constrain(Float64ToVector(append([]float64{}, y...)))
+	J := jacobian(func(y []float64) []float64 { return constrainValues(stickBreaking, y)[:2] }, y, 1e-6)
+	if want := math.Log(math.Abs(determinant(J))); math.Abs(logJacobian.GetValue()-want) > 1e-6 {
+		t.Errorf("StickBreaking: log Jacobian %v, want %v", logJacobian.GetValue(), want)
+	}
+}
@@ -1,6 +1,7 @@
 package bmc
 
 import (
+	"math"
 	"math/rand"
 
 	ad "github.com/pbenner/autodiff"
@@ -17,6 +18,42 @@ func minusLogDist(dist distribution) logDistribution {
 	}
 }
 
+// minusDist is the potential energy of a target that returns a log density
+func minusDist(dist distribution) logDistribution {
+	return func(x ad.Vector) ad.Scalar {
+		return ads.Neg(dist(x))
+	}
+}
+
+// potentialEnergyOf is the potential energy of a target in the space the sampler moves in
+func potentialEnergyOf(dist distribution, logDensity bool, transform Transform) logDistribution {
+	potentialEnergy := minusLogDist(dist)
+	if logDensity {
+		potentialEnergy = minusDist(dist)
+	}
+	if transform != nil {
+		return transformedPotential(potentialEnergy, transform)
+	}
+	return potentialEnergy
+}
+
+// samplerEnergyOf is the potential energy a sampler moves on. It is that of the target, except
+// for SGHMC with a Minibatch, whose potential energy is estimated on a new batch at every evaluation.
+func samplerEnergyOf(dist distribution, config BrownianMonteCarlo) logDistribution {
+	if minibatch := minibatchOf(config.Sampler); minibatch != nil {
+		return potentialEnergyOf(minibatch.batchTarget(), true, config.Transform)
+	}
+	return potentialEnergyOf(dist, config.LogDensity, config.Transform)
+}
+
+// minibatchOf returns the Minibatch of an SGHMC sampler, nil for other samplers
+func minibatchOf(sampler MCMC) *Minibatch {
+	if sghmc, ok := sampler.(SGHMC); ok {
+		return sghmc.Minibatch
+	}
+	return nil
+}
+
 func calculateCollisionCoefficients(masses []ad.Scalar) [][]map[string]ad.Scalar {
 	return nil
 }
@@ -28,32 +65,41 @@ func clone(x ad.Vector) ad.Vector {
 }
 
 // sampleZeroMeanNormal samples a vector from multivariate normal distribution whose mean is zero.
-func sampleZeroMeanNormal(dim int, covariance ad.Matrix) ad.Vector {
+// It returns a *MetricError if the covariance is not positive definite.
+func sampleZeroMeanNormal(dim int, covariance ad.Matrix) (ad.Vector, error) {
 	sample := make([]float64, dim)
 	for i := range sample {
 		sample[i] = rand.NormFloat64()
 	}
-	Z := ad.NewVector(ad.RealType, sample)
 	var chol mat.Cholesky
-	var A ad.Matrix
-	if covariance.Equals(ad.IdentityMatrix(ad.RealType, dim), 0.0001) {
-		A = covariance
-	} else {
-		Sigma := mat.NewSymDense(dim, covariance.GetValues())
-		ok := chol.Factorize(Sigma)
-		if !ok {
-			panic("Can't generate multivariate normal distribution")
-		}
-		buffer := make([]float64, 0)
-		for i := 0; i != dim; i++ {
-			for j := 0; j != dim; j++ {
-				buffer = append(buffer, chol.At(i, j))
-			}
-		}
-		A = ad.NewMatrix(ad.RealType, dim, dim, buffer)
+	if !chol.Factorize(mat.NewSymDense(dim, covariance.GetValues())) {
+		return nil, &MetricError{Dim: dim}
+	}
+	var L mat.TriDense
+	chol.LTo(&L)
+	return Float64ToVector(mulVec(&L, sample)), nil
+}
+
+// sampleMomentum samples a momentum from N(0, mass I), mass must be positive
+func sampleMomentum(dim int, mass ad.Scalar) ad.Vector {
+	scale := math.Sqrt(mass.GetValue())
+	sample := make([]float64, dim)
+	for i := range sample {
+		sample[i] = scale * rand.NormFloat64()
 	}
-	AZ := ads.MdotV(A, Z)
-	return AZ
+	return Float64ToVector(sample)
+}
+
+func isFinite(x float64) bool {
+	return !math.IsNaN(x) && !math.IsInf(x, 0)
+}
+
+// mulVec returns Ax
+func mulVec(A mat.Matrix, x []float64) []float64 {
+	r, _ := A.Dims()
+	Ax := make([]float64, r)
+	mat.NewVecDense(r, Ax).MulVec(A, mat.NewVecDense(len(x), x))
+	return Ax
 }
 
 func kineticEnergy(momentum ad.Vector, mass ad.Scalar) ad.Scalar {
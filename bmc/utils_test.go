@@ -0,0 +1,160 @@
+package bmc
+
+import (
+	"math"
+	"testing"
+
+	ad "github.com/pbenner/autodiff"
+	ads "github.com/pbenner/autodiff/simple"
+)
+
+// standardNormal is the potential energy of a standard Gaussian
+func standardNormal(x ad.Vector) ad.Scalar {
+	return ads.Mul(ad.NewReal(0.5), ads.VdotV(x, x))
+}
+
+// anharmonic is a non-quadratic potential so that leapfrog is a nonlinear map
+func anharmonic(x ad.Vector) ad.Scalar {
+	r2 := ads.VdotV(x, x)
+	return ads.Add(ads.Mul(ad.NewReal(0.25), ads.Mul(r2, r2)), ads.Mul(ad.NewReal(0.5), r2))
+}
+
+func assertClose(t *testing.T, name string, got, want []float64, tol float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: length %d, want %d", name, len(got), len(want))
+	}
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > tol {
+			t.Errorf("%s[%d] = %v, want %v (tol %v)", name, i, got[i], want[i], tol)
+		}
+	}
+}
+
+// leapfrogMap applies n leapfrog steps to z = (x, p) and returns (x', p') as one vector
+func leapfrogMap(z []float64, n int, stepSize float64, potentialEnergy logDistribution, mass float64) []float64 {
+	dim := len(z) / 2
+	x := Float64ToVector(append([]float64{}, z[:dim]...))
+	p := Float64ToVector(append([]float64{}, z[dim:]...))
+	for i := 0; i != n; i++ {
+		x, p = leapfrog(x, p, ad.NewReal(stepSize), potentialEnergy, ad.NewReal(mass))
+	}
+	return append(append([]float64{}, x.GetValues()...), p.GetValues()...)
+}
+
+// jacobian approximates the Jacobian of f at z by central differences
+func jacobian(f func([]float64) []float64, z []float64, h float64) [][]float64 {
+	n := len(z)
+	J := make([][]float64, n)
+	for i := range J {
+		J[i] = make([]float64, n)
+	}
+	for j := 0; j != n; j++ {
+		zPlus := append([]float64{}, z...)
+		zMinus := append([]float64{}, z...)
+		zPlus[j] += h
+		zMinus[j] -= h
+		fPlus, fMinus := f(zPlus), f(zMinus)
+		for i := 0; i != n; i++ {
+			J[i][j] = (fPlus[i] - fMinus[i]) / (2 * h)
+		}
+	}
+	return J
+}
+
+// determinant computes a determinant by Gaussian elimination with partial pivoting
+func determinant(A [][]float64) float64 {
+	n := len(A)
+	M := make([][]float64, n)
+	for i := range A {
+		M[i] = append([]float64{}, A[i]...)
+	}
+	det := 1.
+	for k := 0; k != n; k++ {
+		pivot := k
+		for i := k + 1; i != n; i++ {
+			if math.Abs(M[i][k]) > math.Abs(M[pivot][k]) {
+				pivot = i
+			}
+		}
+		if M[pivot][k] == 0 {
+			return 0
+		}
+		if pivot != k {
+			M[k], M[pivot] = M[pivot], M[k]
+			det = -det
+		}
+		det *= M[k][k]
+		for i := k + 1; i != n; i++ {
+			factor := M[i][k] / M[k][k]
+			for j := k; j != n; j++ {
+				M[i][j] -= factor * M[k][j]
+			}
+		}
+	}
+	return det
+}
+
+func TestLeapfrogReversible(t *testing.T) {
+	for _, mass := range []float64{1, 2.5} {
+		x0 := []float64{0.3, -1.2}
+		p0 := []float64{0.7, 0.4}
+		z := leapfrogMap(append(append([]float64{}, x0...), p0...), 20, 0.1, anharmonic, mass)
+		// flip momentum and integrate back
+		z[2], z[3] = -z[2], -z[3]
+		back := leapfrogMap(z, 20, 0.1, anharmonic, mass)
+		assertClose(t, "x", back[:2], x0, 1e-9)
+		assertClose(t, "p", []float64{-back[2], -back[3]}, p0, 1e-9)
+	}
+}
+
+func TestLeapfrogSymplectic(t *testing.T) {
+	z := []float64{0.3, -1.2, 0.7, 0.4}
+	f := func(z []float64) []float64 { return leapfrogMap(z, 5, 0.1, anharmonic, 1.5) }
+	J := jacobian(f, z, 1e-5)
+
+	// volume preservation
+	if det := determinant(J); math.Abs(det-1) > 1e-6 {
+		t.Errorf("det J = %v, want 1", det)
+	}
+	// J^T Omega J = Omega with Omega = [[0, I], [-I, 0]]
+	n, dim := len(z), len(z)/2
+	omega := func(i, j int) float64 {
+		switch {
+		case i < dim && j == i+dim:
+			return 1
+		case i >= dim && j == i-dim:
+			return -1
+		default:
+			return 0
+		}
+	}
+	for i := 0; i != n; i++ {
+		for j := 0; j != n; j++ {
+			sum := 0.
+			for k := 0; k != n; k++ {
+				for l := 0; l != n; l++ {
+					sum += J[k][i] * omega(k, l) * J[l][j]
+				}
+			}
+			if math.Abs(sum-omega(i, j)) > 1e-6 {
+				t.Errorf("(J^T Omega J)[%d][%d] = %v, want %v", i, j, sum, omega(i, j))
+			}
+		}
+	}
+}
+
+func TestLeapfrogEnergyError(t *testing.T) {
+	x := Float64ToVector([]float64{1, -0.5})
+	p := Float64ToVector([]float64{0.2, 0.8})
+	mass := ad.NewReal(1)
+	H0 := hamiltonian(x, p, mass, standardNormal).GetValue()
+	// The energy error of leapfrog is bounded and O(stepSize^2)
+	for i := 0; i != 1000; i++ {
+		x, p = leapfrog(x, p, ad.NewReal(0.05), standardNormal, mass)
+		H := hamiltonian(x, p, mass, standardNormal).GetValue()
+		if math.Abs(H-H0) > 1e-2 {
+			t.Fatalf("step %d: energy error %v", i, H-H0)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/experiments"
+)
+
+func compareCommand(args []string) error {
+	flags := newFlagSet("compare", "[flags] <run>.json...",
+		"Print the diagnostics of several runs side by side, one column per run.")
+	warmup := flags.Float64("warmup", 0.1, "Fraction of draws of every particle to drop as warmup.")
+	maxKL := flags.Int("maxKL", 2000, "Maximum number of draws for the KL divergence.")
+	if err := parseFlags(flags, args, nil); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("compare: no manifests")
+	}
+	summaries := make([]experiments.Summary, flags.NArg())
+	for i, path := range flags.Args() {
+		run, err := experiments.LoadRun(path, *warmup)
+		if err != nil {
+			return err
+		}
+		summaries[i] = experiments.Summarize(run, *maxKL)
+	}
+	printComparison(os.Stdout, summaries)
+	return nil
+}
+
+// printComparison prints one row per statistic and one column per run
+func printComparison(out io.Writer, summaries []experiments.Summary) {
+	rows := []struct {
+		name  string
+		value func(experiments.Summary) string
+	}{
+		{"run", func(s experiments.Summary) string { return s.Name }},
+		{"sampler", func(s experiments.Summary) string { return s.Sampler }},
+		{"collision", func(s experiments.Summary) string { return s.Collision }},
+		{"target", func(s experiments.Summary) string { return s.Target }},
+		{"particles", func(s experiments.Summary) string { return fmt.Sprint(s.NumParticles) }},
+		{"radius", func(s experiments.Summary) string { return fmt.Sprint(s.Radius) }},
+		{"draws", func(s experiments.Summary) string { return fmt.Sprint(s.NumDraws) }},
+		{"max R-hat", func(s experiments.Summary) string { return fmt.Sprintf("%.3f", s.MaxRHat()) }},
+		{"min ESS", func(s experiments.Summary) string { return fmt.Sprintf("%.0f", s.MinESS()) }},
+		{"KL", func(s experiments.Summary) string { return fmt.Sprintf("%.4f", s.KL) }},
+		{"mode TV", func(s experiments.Summary) string { return fmt.Sprintf("%.4f", s.ModeTV) }},
+		{"acceptance", func(s experiments.Summary) string { return fmt.Sprintf("%.3f", s.MeanAcceptanceRate) }},
+		{"divergences", func(s experiments.Summary) string { return fmt.Sprint(s.NumDivergences) }},
+		{"E-BFMI", func(s experiments.Summary) string { return fmt.Sprintf("%.3f", s.MeanEBFMI) }},
+		{"evaluations/draw", func(s experiments.Summary) string { return fmt.Sprintf("%.1f", s.EvaluationsPerDraw) }},
+		{"seconds", func(s experiments.Summary) string { return fmt.Sprintf("%.1f", s.Elapsed) }},
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprint(w, row.name)
+		for _, s := range summaries {
+			fmt.Fprint(w, "\t", row.value(s))
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"unicode"
+)
+
+// newFlagSet creates the flags of a subcommand with a usage message
+func newFlagSet(name, synopsis, description string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: bmc %s %s\n\n%s\n\nflags:\n", name, synopsis, description)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses args and then fills the flags that are not given on the command line
+// from the JSON object in the file of the -config flag. Keys are flag names, and keys in
+// extras are decoded into the value extras points to.
+func parseFlags(flags *flag.FlagSet, args []string, extras map[string]interface{}) error {
+	configPath := flags.String("config", "", "JSON file of flag values, flags on the command line take precedence.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(*configPath)
+	if err != nil {
+		return err
+	}
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("%s: %v", *configPath, err)
+	}
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for key, raw := range config {
+		if extra, ok := extras[key]; ok {
+			if err := json.Unmarshal(raw, extra); err != nil {
+				return fmt.Errorf("%s: %s: %v", *configPath, key, err)
+			}
+			continue
+		}
+		if flags.Lookup(key) == nil || key == "config" {
+			return fmt.Errorf("%s: unknown key %q", *configPath, key)
+		}
+		if given[key] {
+			continue
+		}
+		value, err := configValue(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", *configPath, key, err)
+		}
+		if err := flags.Set(key, value); err != nil {
+			return fmt.Errorf("%s: %s: %v", *configPath, key, err)
+		}
+	}
+	return nil
+}
+
+// configValue formats a JSON scalar as a flag value
+func configValue(raw json.RawMessage) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	switch value.(type) {
+	case string, json.Number, bool:
+		return fmt.Sprint(value), nil
+	default:
+		return "", fmt.Errorf("value must be a string, number or boolean")
+	}
+}
+
+// splitList splits a flag value separated by white space or by commas outside brackets,
+// so that "L[2,2] sigma" and "beta,L[1,2]" keep the matrix entries whole
+func splitList(value string) []string {
+	var fields []string
+	depth, start := 0, -1
+	for i, r := range value + " " {
+		separator := unicode.IsSpace(r) || r == ',' && depth == 0
+		switch {
+		case r == '[':
+			depth++
+		case r == ']':
+			depth--
+		}
+		if separator && start >= 0 {
+			fields = append(fields, value[start:i])
+			start = -1
+		} else if !separator && start < 0 {
+			start = i
+		}
+	}
+	return fields
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitList(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		want  []string
+	}{
+		{"beta,L[1,2]", []string{"beta", "L[1,2]"}},
+		{"L[2,2] sigma", []string{"L[2,2]", "sigma"}},
+		{"beta[1], beta[2],,sigma ", []string{"beta[1]", "beta[2]", "sigma"}},
+		{"", nil},
+		{" , ", nil},
+	} {
+		if got := splitList(c.value); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitList(%q) = %q, want %q", c.value, got, c.want)
+		}
+	}
+}
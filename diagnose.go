@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"text/tabwriter"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+	"github.com/kim-hyunsu/BrownianMonteCarlo/experiments"
+)
+
+func diagnoseCommand(args []string) error {
+	flags := newFlagSet("diagnose", "-manifest <run>.json [flags]",
+		"Print R-hat and ESS per dimension and generated quantity, acceptance, divergences, E-BFMI and KL per particle,\n"+
+			"moments per particle and pooled over particles with standard errors, and the mode occupancy of mixture targets.")
+	manifestPath := flags.String("manifest", "", "Manifest of the run to diagnose.")
+	warmup := flags.Float64("warmup", 0.1, "Fraction of draws of every particle to drop as warmup.")
+	maxKL := flags.Int("maxKL", 2000, "Maximum number of draws for the KL divergence.")
+	weighting := flags.String("weighting", "ess", "Weights of the particles in pooled moments: ess, mcse (inverse squared MCSE) or equal.")
+	params := flags.String("params", "", "Parameters and generated quantities to list R-hat and ESS of by name, such as \"beta sigma\", default all.")
+	if err := parseFlags(flags, args, nil); err != nil {
+		return err
+	}
+	if *manifestPath == "" {
+		return fmt.Errorf("diagnose: no manifest")
+	}
+	run, err := experiments.LoadRun(*manifestPath, *warmup)
+	if err != nil {
+		return err
+	}
+	if run.Weighting, err = experiments.GetWeighting(*weighting); err != nil {
+		return err
+	}
+	if *params != "" {
+		if err := run.Select(splitList(*params)); err != nil {
+			return err
+		}
+	}
+	summary := experiments.Summarize(run, *maxKL)
+	manifest := run.Manifest
+	labels := manifest.Labels()
+
+	fmt.Printf("%s: %d draws of %d particles\n\n", summary.Name, summary.NumDraws, summary.NumParticles)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "parameter\tR-hat\tESS\t")
+	for _, d := range run.Selected() {
+		fmt.Fprintf(w, "%s\t%.3f\t%.0f\t\n", labels[d], summary.RHat[d], summary.ESS[d])
+	}
+	generatedLabels := manifest.GeneratedLabels()
+	for _, d := range run.SelectedGenerated() {
+		if d < len(summary.GeneratedRHat) {
+			fmt.Fprintf(w, "%s\t%.3f\t%.0f\t\n", generatedLabels[d], summary.GeneratedRHat[d], summary.GeneratedESS[d])
+		}
+	}
+	w.Flush()
+	fmt.Println()
+
+	mixture, isMixture := experiments.GetMixture(manifest.Target)
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "particle\tmass\tacceptance\tdivergences\tE-BFMI\tKL\t")
+	for i, draws := range run.Draws {
+		kl := "-"
+		if isMixture && mixture.Dim() == manifest.Dim {
+			kl = fmt.Sprintf("%.4f", experiments.KLDivergenceKNN(thin(draws, *maxKL), mixture.LogDensity, 5))
+		}
+		fmt.Fprintf(w, "%d\t%g\t%.3f\t%s\t%s\t%s\t\n", i, valueAt(manifest.Masses, i), valueAt(manifest.AcceptanceRate, i),
+			countAt(manifest.NumDivergences, i), pointerAt(manifest.EBFMI, i), kl)
+	}
+	fmt.Fprintf(w, "all\t\t%.3f\t%d\t%.3f\t%.4f\t\n", summary.MeanAcceptanceRate, summary.NumDivergences, summary.MeanEBFMI, summary.KL)
+	w.Flush()
+	fmt.Println()
+	printMoments(os.Stdout, run)
+
+	if isMixture && mixture.Dim() == manifest.Dim {
+		fmt.Println()
+		tracker := experiments.NewModeTracker(mixture, len(run.Draws))
+		for id, draws := range run.Draws {
+			for _, x := range draws {
+				tracker.Add(bmc.Sample{ID: id, X: x})
+			}
+		}
+		printModes(os.Stdout, tracker.Occupancy())
+	}
+	return nil
+}
+
+// printMoments reports the moments of a run estimated by every particle and pooled over particles,
+// with Monte Carlo standard errors, the weights of the particles and their heterogeneity
+func printMoments(out io.Writer, run experiments.Run) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "moment\tparticle\tmass\testimate\tMCSE\tESS\tweight\t")
+	pooled := make([]experiments.PooledEstimate, 0)
+	moments := run.Moments()
+	for _, m := range moments {
+		estimate := experiments.Pool(run.Draws, m.Moment, run.Weighting)
+		pooled = append(pooled, estimate)
+		for i, e := range estimate.Particles {
+			fmt.Fprintf(w, "%s\t%d\t%g\t%.4f\t%.4f\t%.0f\t%.3f\t\n", m.Name, i, valueAt(run.Manifest.Masses, i),
+				e.Mean, e.MCSE, e.ESS, estimate.Weights[i])
+		}
+		e := estimate.Pooled
+		fmt.Fprintf(w, "%s\tpooled\t\t%.4f\t%.4f\t%.0f\t\t\n", m.Name, e.Mean, e.MCSE, e.ESS)
+	}
+	w.Flush()
+	fmt.Fprintln(out)
+	for k, m := range moments {
+		estimate := pooled[k]
+		fmt.Fprintf(out, "%s: %.4f +- %.4f with %s weights", m.Name, estimate.Pooled.Mean, estimate.Pooled.MCSE, run.Weighting)
+		if !math.IsNaN(m.Truth) {
+			fmt.Fprintf(out, ", truth %.4f", m.Truth)
+		}
+		fmt.Fprintf(out, ", heterogeneity of particles Q %.2f (p %.3f)\n", estimate.Heterogeneity, estimate.PValue)
+	}
+}
+
+// printModes reports the mode occupancy of every particle and of all particles
+func printModes(out io.Writer, occupancy experiments.ModeOccupancy) {
+	report := func(name string, statistics experiments.ModeStatistics) {
+		fmt.Fprintf(out, "%s: occupancy %.3f, switches %d, mean dwell %.1f, weight TV %.4f\n",
+			name, statistics.Occupancy, statistics.NumSwitches, statistics.MeanDwellTime, statistics.TotalVariation)
+	}
+	for i, statistics := range occupancy.Particles {
+		report(fmt.Sprintf("particle %d", i), statistics)
+	}
+	report("overall", occupancy.Overall)
+}
+
+// thin keeps at most n evenly spaced draws
+func thin(draws [][]float64, n int) [][]float64 {
+	if len(draws) <= n {
+		return draws
+	}
+	thinned := make([][]float64, n)
+	for i := range thinned {
+		thinned[i] = draws[i*len(draws)/n]
+	}
+	return thinned
+}
+
+func valueAt(xs []float64, i int) float64 {
+	if i < len(xs) {
+		return xs[i]
+	}
+	return 0
+}
+
+func countAt(ns []int, i int) string {
+	if i < len(ns) {
+		return fmt.Sprint(ns[i])
+	}
+	return "-"
+}
+
+func pointerAt(xs []*float64, i int) string {
+	if i < len(xs) && xs[i] != nil {
+		return fmt.Sprintf("%.3f", *xs[i])
+	}
+	return "-"
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"text/tabwriter"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+	"github.com/kim-hyunsu/BrownianMonteCarlo/experiments"
+	ad "github.com/pbenner/autodiff"
+)
+
+func evidenceCommand(args []string) error {
+	flags := newFlagSet("evidence", "-manifest <run>.json [flags]",
+		"Estimate the log normalizing constant of the target of a run by importance sampling and\n"+
+			"bridge sampling against a Gaussian mixture fitted to the first half of the draws, and by\n"+
+			"thermodynamic integration over a temperature ladder if -ladder is set. For mixture targets\n"+
+			"the estimates are compared to the known constant.")
+	manifestPath := flags.String("manifest", "", "Manifest of the run.")
+	warmup := flags.Float64("warmup", 0.1, "Fraction of draws of every particle to drop as warmup.")
+	numComponents := flags.Int("components", 0, "Components of the fitted proposal, 0 uses those of a mixture target or 1.")
+	numIterations := flags.Int("iterations", 200, "Maximum number of EM iterations of the fit.")
+	numProposals := flags.Int("numProposals", 10000, "Number of draws of the proposal.")
+	numRungs := flags.Int("ladder", 0, "Number of inverse temperatures of thermodynamic integration, 0 disables it.")
+	ladderDraws := flags.Int("ladderDraws", 200, "Draws of every particle per rung of the ladder, after as many warmup draws.")
+	seed := flags.Int64("seed", 1, "Seed of the proposal draws and of the ladder.")
+	if err := parseFlags(flags, args, nil); err != nil {
+		return err
+	}
+	if *manifestPath == "" {
+		return fmt.Errorf("evidence: no manifest")
+	}
+	if *numRungs == 1 {
+		return fmt.Errorf("evidence: a ladder needs at least 2 rungs")
+	}
+	run, err := experiments.LoadRun(*manifestPath, *warmup)
+	if err != nil {
+		return err
+	}
+	manifest := run.Manifest
+	dist := experiments.GetDistribution(manifest.Target)
+	if dist == nil {
+		return fmt.Errorf("evidence: unknown target %q", manifest.Target)
+	}
+	logTarget := experiments.LogDensityOf(dist)
+	rng := rand.New(rand.NewSource(*seed))
+	// BMC draws from the global source
+	rand.Seed(*seed)
+
+	// the proposal is fitted to the first half of the draws and bridged with the second half
+	fitted := make([][]float64, 0)
+	bridged := make([][][]float64, len(run.Draws))
+	for i, draws := range run.Draws {
+		fitted = append(fitted, draws[:len(draws)/2]...)
+		bridged[i] = draws[len(draws)/2:]
+	}
+	K := *numComponents
+	if K == 0 {
+		K = 1
+		if mixture, ok := experiments.GetMixture(manifest.Target); ok {
+			K = len(mixture.Weights)
+		}
+	}
+	proposal, err := experiments.FitMixture(fitted, K, *numIterations, rng)
+	if err != nil {
+		return err
+	}
+
+	estimates := []experiments.Evidence{experiments.ImportanceSampling(logTarget, proposal, *numProposals, rng)}
+	bridge, err := experiments.BridgeSampling(logTarget, proposal, bridged, *numProposals, rng)
+	if err != nil {
+		return err
+	}
+	estimates = append(estimates, bridge)
+	if *numRungs > 1 {
+		tempered := func(beta float64) bmc.Target { return experiments.TemperedTarget(dist, proposal, beta) }
+		ladder, err := experiments.SampleLadder(ladderTemplate(manifest, *ladderDraws), tempered, proposal,
+			experiments.PowerLadder(*numRungs), *ladderDraws, rng)
+		if err != nil {
+			return err
+		}
+		ti, err := experiments.ThermodynamicIntegration(ladder, logTarget, proposal)
+		if err != nil {
+			return err
+		}
+		estimates = append(estimates, ti)
+	}
+	logZ, known := experiments.LogNormalizingConstant(manifest.Target)
+	printEvidence(os.Stdout, estimates, logZ, known)
+	return nil
+}
+
+// ladderTemplate configures BMC for the rungs of a ladder like the run of the manifest,
+// with NUTS adapting its step size during numWarmup draws
+func ladderTemplate(manifest experiments.Manifest, numWarmup int) bmc.BrownianMonteCarlo {
+	collide := bmc.NoCollision
+	if manifest.Collision == "NormalCollision" {
+		collide = bmc.NormalCollision
+	}
+	masses := make([]ad.Scalar, manifest.NumParticles)
+	radii := make([]float64, manifest.NumParticles)
+	for i := range masses {
+		masses[i] = ad.NewScalar(ad.RealType, manifest.Masses[i])
+		radii[i] = manifest.Radius
+	}
+	return bmc.BrownianMonteCarlo{
+		Sampler:      bmc.NUTS{StepSize: ad.NewScalar(ad.RealType, 0)},
+		Collide:      collide,
+		NumParticles: manifest.NumParticles,
+		Radius:       radii,
+		Masses:       masses,
+		MaxAdapt:     numWarmup,
+	}
+}
+
+// printEvidence lists the estimates, with their deviation from the known log normalizing constant
+func printEvidence(out io.Writer, estimates []experiments.Evidence, logZ float64, known bool) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "method\tlog Z\tstandard error\terror\tz\t")
+	for _, e := range estimates {
+		deviation, z := "-", "-"
+		if known {
+			deviation = fmt.Sprintf("%.4f", e.LogZ-logZ)
+			z = fmt.Sprintf("%.2f", (e.LogZ-logZ)/e.StandardError)
+		}
+		fmt.Fprintf(w, "%s\t%.4f\t%.4f\t%s\t%s\t\n", e.Method, e.LogZ, e.StandardError, deviation, z)
+	}
+	if known {
+		fmt.Fprintf(w, "known\t%.4f\t\t\t\t\n", logZ)
+	}
+	w.Flush()
+}
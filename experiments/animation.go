@@ -0,0 +1,189 @@
+package experiments
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	colorpalette "image/color/palette"
+	imagedraw "image/draw"
+	"image/gif"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+	ad "github.com/pbenner/autodiff"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+)
+
+// Animation renders the frames recorded by a 2-D BMC run
+type Animation struct {
+	Frames []bmc.Frame
+	// Target is drawn as contours behind the particles, nil draws no contours
+	Target Distribution
+	// Every keeps every n-th frame (default 1)
+	Every int
+	// Delay between frames of a GIF in 100ths of a second (default 10)
+	Delay int
+	// MomentumScale is the length of the momentum arrows per unit of momentum (default 0.5)
+	MomentumScale float64
+	// Size is the width and height of a frame (default 5 inches)
+	Size vg.Length
+}
+
+// Save writes the animation as an animated GIF if path ends with .gif,
+// otherwise as a sequence of PNGs frame_00000.png, ... in the directory path
+func (animation Animation) Save(path string) error {
+	if len(animation.Frames) == 0 {
+		return fmt.Errorf("animation: no frames")
+	}
+	if len(animation.Frames[0].X[0]) != 2 {
+		return fmt.Errorf("animation: frames have %d dimensions, want 2", len(animation.Frames[0].X[0]))
+	}
+	if animation.Every == 0 {
+		animation.Every = 1
+	}
+	if animation.Delay == 0 {
+		animation.Delay = 10
+	}
+	if animation.MomentumScale == 0 {
+		animation.MomentumScale = 0.5
+	}
+	if animation.Size == 0 {
+		animation.Size = 5 * vg.Inch
+	}
+	xMin, xMax, yMin, yMax := animation.bounds()
+	var contours densityGrid
+	if animation.Target != nil {
+		target := animation.Target
+		contours = evaluateGrid(span(xMin, xMax, 80), span(yMin, yMax, 80), func(x, y float64) float64 {
+			return target(ad.NewVector(ad.RealType, []float64{x, y})).GetValue()
+		})
+	}
+
+	isGIF := strings.HasSuffix(path, ".gif")
+	if !isGIF {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+	}
+	animated := &gif.GIF{}
+	for k := 0; k < len(animation.Frames); k += animation.Every {
+		frame := animation.Frames[k]
+		p, err := animation.plotFrame(frame, contours, xMin, xMax, yMin, yMax)
+		if err != nil {
+			return err
+		}
+		img := vgimg.New(animation.Size, animation.Size)
+		p.Draw(draw.New(img))
+		if isGIF {
+			bounds := img.Image().Bounds()
+			paletted := image.NewPaletted(bounds, colorpalette.Plan9)
+			imagedraw.FloydSteinberg.Draw(paletted, bounds, img.Image(), image.Point{})
+			animated.Image = append(animated.Image, paletted)
+			animated.Delay = append(animated.Delay, animation.Delay)
+			continue
+		}
+		if err := savePNG(filepath.Join(path, fmt.Sprintf("frame_%05d.png", k/animation.Every)), img.Image()); err != nil {
+			return err
+		}
+	}
+	if !isGIF {
+		return nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := gif.EncodeAll(file, animated); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// plotFrame draws the particles as circles of their radius with their momenta,
+// collided particles are drawn thick and red
+func (animation Animation) plotFrame(frame bmc.Frame, contours densityGrid, xMin, xMax, yMin, yMax float64) (*plot.Plot, error) {
+	p, err := newPlot(fmt.Sprintf("Iteration %d", frame.Iteration), "", "")
+	if err != nil {
+		return nil, err
+	}
+	p.X.Min, p.X.Max, p.Y.Min, p.Y.Max = xMin, xMax, yMin, yMax
+	if contours.xs != nil {
+		p.Add(plotter.NewContour(contours, levels(contours), singleColor{color.Gray{Y: 160}}))
+	}
+	collided := make(map[int]bool)
+	for _, id := range frame.Collided {
+		collided[id] = true
+	}
+	for i, x := range frame.X {
+		// negative radii collide like their absolute value, see NormalCollision
+		r := math.Abs(frame.Radius[i])
+		circle := make(plotter.XYs, 33)
+		for k := range circle {
+			angle := 2 * math.Pi * float64(k) / float64(len(circle)-1)
+			circle[k].X = x[0] + r*math.Cos(angle)
+			circle[k].Y = x[1] + r*math.Sin(angle)
+		}
+		arrow := plotter.XYs{
+			{X: x[0], Y: x[1]},
+			{X: x[0] + animation.MomentumScale*frame.P[i][0], Y: x[1] + animation.MomentumScale*frame.P[i][1]},
+		}
+		for _, xys := range []plotter.XYs{circle, arrow} {
+			line, err := plotter.NewLine(xys)
+			if err != nil {
+				return nil, err
+			}
+			line.LineStyle.Color = particleColor(i, len(frame.X))
+			line.LineStyle.Width = vg.Points(1)
+			if collided[i] {
+				line.LineStyle.Color = color.RGBA{R: 255, A: 255}
+				line.LineStyle.Width = vg.Points(3)
+			}
+			p.Add(line)
+		}
+	}
+	return p, nil
+}
+
+// bounds returns a range that holds every particle of every frame with its radius
+func (animation Animation) bounds() (xMin, xMax, yMin, yMax float64) {
+	xMin, yMin = math.Inf(1), math.Inf(1)
+	xMax, yMax = math.Inf(-1), math.Inf(-1)
+	for _, frame := range animation.Frames {
+		for i, x := range frame.X {
+			r := math.Abs(frame.Radius[i])
+			xMin, xMax = math.Min(xMin, x[0]-r), math.Max(xMax, x[0]+r)
+			yMin, yMax = math.Min(yMin, x[1]-r), math.Max(yMax, x[1]+r)
+		}
+	}
+	return xMin, xMax, yMin, yMax
+}
+
+// span returns n points from min to max
+func span(min, max float64, n int) []float64 {
+	points := make([]float64, n)
+	for i := range points {
+		points[i] = min + (max-min)*float64(i)/float64(n-1)
+	}
+	return points
+}
+
+func savePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		return err
+	}
+	return file.Close()
+}
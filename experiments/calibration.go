@@ -0,0 +1,196 @@
+package experiments
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+	ad "github.com/pbenner/autodiff"
+	ads "github.com/pbenner/autodiff/simple"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// CalibrationTarget is a target distribution that can also be sampled exactly
+type CalibrationTarget struct {
+	Name    string
+	Dim     int
+	Density Distribution
+	Draw    func() []float64
+}
+
+// GetCalibrationTarget gets name of calibration target and return corresponding target
+func GetCalibrationTarget(name string) (CalibrationTarget, bool) {
+	switch name {
+	case "ProductGaussian2d":
+		return ProductGaussian2d(), true
+	case "LatticeMOG2d":
+		return LatticeMOG2d(), true
+	default:
+		return CalibrationTarget{}, false
+	}
+}
+
+// ProductGaussian2d is a product of two 1d Gaussians with different means and scales
+func ProductGaussian2d() CalibrationTarget {
+	means := []float64{1., -2.}
+	scales := []float64{0.5, 2.}
+	A := ad.NewMatrix(ad.RealType, 2, 2, []float64{1 / (scales[0] * scales[0]), 0., 0., 1 / (scales[1] * scales[1])})
+	mu := ad.NewVector(ad.RealType, means)
+	return CalibrationTarget{
+		Name: "ProductGaussian2d",
+		Dim:  2,
+		Density: func(x ad.Vector) ad.Scalar {
+			v := ads.VsubV(x, mu)
+			vAv := ads.VdotV(v, ads.MdotV(A, v))
+			return ads.Exp(ads.Mul(ad.NewReal(-1), ads.Div(vAv, ad.NewReal(2))))
+		},
+		Draw: func() []float64 {
+			x := make([]float64, 2)
+			for i := range x {
+				x[i] = means[i] + scales[i]*rand.NormFloat64()
+			}
+			return x
+		},
+	}
+}
+
+// LatticeMOG2d is a product of two 1d mixtures of narrow Gaussians on the lattice {-3, 0, 3}.
+// It behaves like a discrete-support toy whose mode weights are known.
+func LatticeMOG2d() CalibrationTarget {
+	sites := []float64{-3., 0., 3.}
+	weights := []float64{0.2, 0.5, 0.3}
+	scale := 0.5
+	return CalibrationTarget{
+		Name: "LatticeMOG2d",
+		Dim:  2,
+		Density: func(x ad.Vector) ad.Scalar {
+			// Each coordinate is an independent mixture, so the density is
+			// a mixture over the 3x3 grid with product weights.
+			MOG := ad.NewScalar(ad.RealType, 0)
+			for i := range sites {
+				for j := range sites {
+					v := ads.VsubV(x, ad.NewVector(ad.RealType, []float64{sites[i], sites[j]}))
+					v2 := ads.Div(ads.VdotV(v, v), ad.NewReal(scale*scale))
+					gaussian := ads.Exp(ads.Mul(ad.NewReal(-1), ads.Div(v2, ad.NewReal(2))))
+					MOG = ads.Add(MOG, ads.Mul(ad.NewReal(weights[i]*weights[j]), gaussian))
+				}
+			}
+			return MOG
+		},
+		Draw: func() []float64 {
+			x := make([]float64, 2)
+			for d := range x {
+				u, i := rand.Float64(), 0
+				for ; i != len(weights)-1 && u > weights[i]; i++ {
+					u -= weights[i]
+				}
+				x[d] = sites[i] + scale*rand.NormFloat64()
+			}
+			return x
+		},
+	}
+}
+
+// SBC holds rank statistics of simulation-based calibration
+type SBC struct {
+	NumDraws   int
+	NumBins    int
+	Ranks      [][][]int // [particle][dim][replicate]
+	Histograms [][][]int // [particle][dim][bin]
+}
+
+// SimulationBasedCalibration runs BMC once per replicate and ranks an exact draw
+// from the target among thinned draws of every particle. Each replicate starts
+// from another exact draw, so a sampler that leaves the target invariant gives
+// uniform ranks. BMC is used as a template and should have a fixed step size.
+func SimulationBasedCalibration(
+	BMC bmc.BrownianMonteCarlo,
+	target CalibrationTarget,
+	numReplicates, numDraws, thin, numBins int,
+) (SBC, error) {
+	sbc := SBC{
+		NumDraws:   numDraws,
+		NumBins:    numBins,
+		Ranks:      make([][][]int, BMC.NumParticles),
+		Histograms: make([][][]int, BMC.NumParticles),
+	}
+	for i := range sbc.Ranks {
+		sbc.Ranks[i] = make([][]int, target.Dim)
+		sbc.Histograms[i] = make([][]int, target.Dim)
+		for d := range sbc.Histograms[i] {
+			sbc.Histograms[i][d] = make([]int, numBins)
+		}
+	}
+	for r := 0; r != numReplicates; r++ {
+		reference := target.Draw()
+		draws, err := runReplicate(BMC, target, numDraws*thin)
+		if err != nil {
+			return sbc, err
+		}
+		for i := range draws {
+			for d := 0; d != target.Dim; d++ {
+				rank := 0
+				for k := thin - 1; k < len(draws[i]); k += thin {
+					if draws[i][k][d] < reference[d] {
+						rank++
+					}
+				}
+				sbc.Ranks[i][d] = append(sbc.Ranks[i][d], rank)
+				sbc.Histograms[i][d][rank*numBins/(numDraws+1)]++
+			}
+		}
+	}
+	return sbc, nil
+}
+
+func runReplicate(template bmc.BrownianMonteCarlo, target CalibrationTarget, numDraws int) ([][][]float64, error) {
+	BMC := template
+	BMC.Radius = make([]float64, len(template.Radius))
+	copy(BMC.Radius, template.Radius)
+	sample := make(chan bmc.Sample)
+	collidedSample := make(chan bmc.Sample, 1)
+	err := BMC.Sample(target.Density, ad.NewVector(ad.RealType, target.Draw()), sample, collidedSample)
+	if err != nil {
+		return nil, err
+	}
+
+	draws := make([][][]float64, BMC.NumParticles)
+	for received := 0; received != numDraws*BMC.NumParticles; received++ {
+		s, ok := <-sample
+		if !ok {
+			return nil, BMC.Err()
+		}
+		draws[s.ID] = append(draws[s.ID], s.X)
+	}
+	BMC.Stop()
+	return draws, nil
+}
+
+// ChiSquare returns the chi-square statistic of every rank histogram against
+// the uniform distribution and its p-value, indexed by [particle][dim].
+func (sbc SBC) ChiSquare() (statistics, pValues [][]float64) {
+	statistics = make([][]float64, len(sbc.Histograms))
+	pValues = make([][]float64, len(sbc.Histograms))
+	chi2 := distuv.ChiSquared{K: float64(sbc.NumBins - 1)}
+	for i, histograms := range sbc.Histograms {
+		for _, histogram := range histograms {
+			total := 0
+			for _, count := range histogram {
+				total += count
+			}
+			// ranks 0..NumDraws are spread over NumBins bins
+			statistic := 0.
+			for b, count := range histogram {
+				lo := (b*(sbc.NumDraws+1) + sbc.NumBins - 1) / sbc.NumBins
+				hi := ((b+1)*(sbc.NumDraws+1) + sbc.NumBins - 1) / sbc.NumBins
+				expected := float64(total) * float64(hi-lo) / float64(sbc.NumDraws+1)
+				if expected > 0 {
+					statistic += math.Pow(float64(count)-expected, 2) / expected
+				}
+			}
+			statistics[i] = append(statistics[i], statistic)
+			pValues[i] = append(pValues[i], chi2.Survival(statistic))
+		}
+	}
+	return statistics, pValues
+}
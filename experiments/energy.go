@@ -0,0 +1,133 @@
+package experiments
+
+import (
+	"math"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+)
+
+// Histogram counts values in bins of equal width between Min and Max
+type Histogram struct {
+	Min, Max float64
+	Counts   []int
+}
+
+// NewHistogram bins the finite values
+func NewHistogram(values []float64, numBins int) Histogram {
+	histogram := Histogram{Min: math.Inf(1), Max: math.Inf(-1), Counts: make([]int, numBins)}
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		histogram.Min = math.Min(histogram.Min, v)
+		histogram.Max = math.Max(histogram.Max, v)
+	}
+	if histogram.Min > histogram.Max {
+		return histogram
+	}
+	width := (histogram.Max - histogram.Min) / float64(numBins)
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		bin := numBins - 1
+		if width > 0 {
+			bin = int(math.Min((v-histogram.Min)/width, float64(numBins-1)))
+		}
+		histogram.Counts[bin]++
+	}
+	return histogram
+}
+
+// EnergyDiagnostics tells whether transitions and collisions explore the energy levels.
+// Energies are the Hamiltonians at the start of each transition, after the previous collision,
+// so energy transitions are the changes made by the collisions together with the transitions.
+type EnergyDiagnostics struct {
+	// EBFMI is the energy Bayesian fraction of missing information per particle,
+	// values below 0.3 indicate that the energy levels are explored poorly
+	EBFMI []float64
+	// Energy is the centered marginal energy distribution per particle
+	Energy []Histogram
+	// EnergyTransition is the distribution of energy changes between consecutive transitions per particle
+	EnergyTransition []Histogram
+	// CollisionKineticChange is the kinetic energy added by every collision of a particle
+	CollisionKineticChange [][]float64
+	// ResampleKineticChange is the kinetic energy added by the momentum resample of a particle
+	// in the iterations it did not collide
+	ResampleKineticChange [][]float64
+	// MeanAbsCollisionKineticChange is the mean absolute kinetic energy change by collisions
+	// per particle, NaN for a particle that never collided
+	MeanAbsCollisionKineticChange []float64
+}
+
+// NewEnergyDiagnostics computes energy diagnostics from the energies logged by BMC,
+// skipping the first warmup iterations
+func NewEnergyDiagnostics(energies [][]bmc.Energy, warmup, numBins int) EnergyDiagnostics {
+	n := len(energies)
+	diagnostics := EnergyDiagnostics{
+		EBFMI:                         make([]float64, n),
+		Energy:                        make([]Histogram, n),
+		EnergyTransition:              make([]Histogram, n),
+		CollisionKineticChange:        make([][]float64, n),
+		ResampleKineticChange:         make([][]float64, n),
+		MeanAbsCollisionKineticChange: make([]float64, n),
+	}
+	for i, particle := range energies {
+		if warmup < len(particle) {
+			particle = particle[warmup:]
+		} else {
+			particle = nil
+		}
+		start := make([]float64, len(particle))
+		collision, resample, absCollision := make([]float64, 0), make([]float64, 0), make([]float64, 0)
+		for j, energy := range particle {
+			start[j] = energy.Start
+			// the potential does not change in a collision
+			if change := energy.AfterCollision - energy.BeforeCollision; energy.Collided {
+				collision = append(collision, change)
+				absCollision = append(absCollision, math.Abs(change))
+			} else {
+				resample = append(resample, change)
+			}
+		}
+		diagnostics.MeanAbsCollisionKineticChange[i] = meanOrNaN(absCollision)
+		mean := 0.
+		for _, e := range start {
+			mean += e / float64(len(start))
+		}
+		centered := make([]float64, len(start))
+		for j, e := range start {
+			centered[j] = e - mean
+		}
+		transitions := make([]float64, 0, len(start))
+		for j := 1; j < len(start); j++ {
+			transitions = append(transitions, start[j]-start[j-1])
+		}
+		diagnostics.EBFMI[i] = EBFMI(start)
+		diagnostics.Energy[i] = NewHistogram(centered, numBins)
+		diagnostics.EnergyTransition[i] = NewHistogram(transitions, numBins)
+		diagnostics.CollisionKineticChange[i] = collision
+		diagnostics.ResampleKineticChange[i] = resample
+	}
+	return diagnostics
+}
+
+// EBFMI computes the energy Bayesian fraction of missing information (Betancourt, 2016)
+// of a sequence of energies, NaN for fewer than two energies
+func EBFMI(energies []float64) float64 {
+	if len(energies) < 2 {
+		return math.NaN()
+	}
+	mean := 0.
+	for _, e := range energies {
+		mean += e / float64(len(energies))
+	}
+	numerator, denominator := 0., 0.
+	for j, e := range energies {
+		if j > 0 {
+			numerator += (e - energies[j-1]) * (e - energies[j-1])
+		}
+		denominator += (e - mean) * (e - mean)
+	}
+	return numerator / denominator
+}
@@ -0,0 +1,74 @@
+package experiments
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+)
+
+func TestEBFMI(t *testing.T) {
+	for _, c := range []struct {
+		energies []float64
+		want     float64
+	}{
+		// squared differences 1+1 over squared deviations 1+0+1
+		{[]float64{1, 2, 3}, 1},
+		// squared differences 1+1+1 over squared deviations 4*0.25
+		{[]float64{0, 1, 0, 1}, 3},
+		// squared differences 0+4+0 over squared deviations 4*1
+		{[]float64{5, 5, 7, 7}, 1},
+		// squared differences 4+1 over squared deviations 1+1+0
+		{[]float64{0, 2, 1}, 2.5},
+	} {
+		if got := EBFMI(c.energies); math.Abs(got-c.want) > 1e-12 {
+			t.Errorf("EBFMI(%v) = %v, want %v", c.energies, got, c.want)
+		}
+	}
+	for _, energies := range [][]float64{nil, {1}, {2, 2, 2}} {
+		if got := EBFMI(energies); !math.IsNaN(got) {
+			t.Errorf("EBFMI(%v) = %v, want NaN", energies, got)
+		}
+	}
+}
+
+func TestNewHistogram(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		values   []float64
+		min, max float64
+		counts   []int
+	}{
+		// the maximum falls in the last bin
+		{"edges", []float64{0, 1, 2, 3, 4}, 0, 4, []int{1, 1, 1, 2}},
+		{"non-finite", []float64{math.NaN(), 1, math.Inf(1), 3, math.Inf(-1)}, 1, 3, []int{1, 0, 0, 1}},
+		{"constant", []float64{2, 2}, 2, 2, []int{0, 0, 0, 2}},
+		{"empty", []float64{math.NaN()}, math.Inf(1), math.Inf(-1), []int{0, 0, 0, 0}},
+	} {
+		h := NewHistogram(c.values, 4)
+		if h.Min != c.min || h.Max != c.max || !reflect.DeepEqual(h.Counts, c.counts) {
+			t.Errorf("%s: histogram %+v, want min %v, max %v, counts %v", c.name, h, c.min, c.max, c.counts)
+		}
+	}
+}
+
+func TestEnergyDiagnosticsCollisions(t *testing.T) {
+	energies := [][]bmc.Energy{{
+		{Start: 1, BeforeCollision: 1, AfterCollision: 2, Collided: true},
+		{Start: 2, BeforeCollision: 2, AfterCollision: 1.5},
+		{Start: 1.5, BeforeCollision: 1.5, AfterCollision: 0.5, Collided: true},
+	}, {
+		{Start: 1, BeforeCollision: 1, AfterCollision: 3},
+	}}
+	diagnostics := NewEnergyDiagnostics(energies, 0, 4)
+	if got := diagnostics.CollisionKineticChange[0]; !reflect.DeepEqual(got, []float64{1, -1}) {
+		t.Errorf("collision changes %v, want [1 -1]", got)
+	}
+	if got := diagnostics.ResampleKineticChange[0]; !reflect.DeepEqual(got, []float64{-0.5}) {
+		t.Errorf("resample changes %v, want [-0.5]", got)
+	}
+	if got := diagnostics.MeanAbsCollisionKineticChange; got[0] != 1 || !math.IsNaN(got[1]) {
+		t.Errorf("mean absolute collision changes %v, want [1 NaN]", got)
+	}
+}
@@ -0,0 +1,438 @@
+package experiments
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+	ad "github.com/pbenner/autodiff"
+	ads "github.com/pbenner/autodiff/simple"
+)
+
+// Evidence is an estimate of the log normalizing constant of an unnormalized target
+type Evidence struct {
+	Method string
+	LogZ   float64
+	// StandardError is the standard error of LogZ
+	StandardError float64
+}
+
+// LogDensityOf returns the logarithm of an unnormalized density on plain vectors
+func LogDensityOf(dist Distribution) func([]float64) float64 {
+	return func(x []float64) float64 {
+		return math.Log(dist(ad.NewVector(ad.RealType, x)).GetValue())
+	}
+}
+
+// LogNormalizingConstant returns the log normalizing constant of a mixture target,
+// the offset between its density and the normalized density of its mixture
+func LogNormalizingConstant(name string) (float64, bool) {
+	dist := GetDistribution(name)
+	mixture, ok := GetMixture(name)
+	if dist == nil || !ok {
+		return 0, false
+	}
+	x := mixture.Means[0]
+	return LogDensityOf(dist)(x) - mixture.LogDensity(x), true
+}
+
+// Sample draws from the mixture with rng
+func (mixture Mixture) Sample(rng *rand.Rand) []float64 {
+	u, k := rng.Float64(), 0
+	for ; k < len(mixture.Weights)-1 && u >= mixture.Weights[k]; k++ {
+		u -= mixture.Weights[k]
+	}
+	x := make([]float64, mixture.Dim())
+	for i := range x {
+		x[i] = mixture.Means[k][i] + math.Sqrt(mixture.Variances[k][i])*rng.NormFloat64()
+	}
+	return x
+}
+
+// FitMixture fits a Gaussian mixture with K diagonal components to draws by expectation
+// maximization. The means start at draws chosen by rng with probability proportional to
+// their squared distance from the means chosen before, so that separated modes are found.
+func FitMixture(draws [][]float64, K, numIterations int, rng *rand.Rand) (Mixture, error) {
+	if len(draws) < K || K <= 0 {
+		return Mixture{}, fmt.Errorf("%d draws for %d components", len(draws), K)
+	}
+	n, dim := len(draws), len(draws[0])
+	mean, variance := make([]float64, dim), make([]float64, dim)
+	for _, x := range draws {
+		for i, xi := range x {
+			mean[i] += xi / float64(n)
+		}
+	}
+	for _, x := range draws {
+		for i, xi := range x {
+			variance[i] += (xi - mean[i]) * (xi - mean[i]) / float64(n)
+		}
+	}
+	floor := make([]float64, dim)
+	for i := range floor {
+		floor[i] = 1e-6*variance[i] + 1e-12
+	}
+
+	mixture := Mixture{Weights: make([]float64, K), Means: make([][]float64, K), Variances: make([][]float64, K)}
+	distances := make([]float64, n)
+	for i := range distances {
+		distances[i] = math.Inf(1)
+	}
+	chosen := rng.Intn(n)
+	for k := 0; k != K; k++ {
+		mixture.Weights[k] = 1 / float64(K)
+		mixture.Means[k] = append([]float64{}, draws[chosen]...)
+		mixture.Variances[k] = append([]float64{}, variance...)
+		total := 0.
+		for j, x := range draws {
+			distances[j] = math.Min(distances[j], squaredDistance(x, mixture.Means[k]))
+			total += distances[j]
+		}
+		u := rng.Float64() * total
+		for chosen = 0; chosen < n-1 && u >= distances[chosen]; chosen++ {
+			u -= distances[chosen]
+		}
+	}
+
+	responsibilities := make([][]float64, n)
+	for j := range responsibilities {
+		responsibilities[j] = make([]float64, K)
+	}
+	previous := math.Inf(-1)
+	for iteration := 0; iteration != numIterations; iteration++ {
+		// E step
+		logLikelihood := 0.
+		for j, x := range draws {
+			max := math.Inf(-1)
+			for k := range mixture.Weights {
+				responsibilities[j][k] = math.Log(mixture.Weights[k]) + logNormalDiagonal(x, mixture.Means[k], mixture.Variances[k])
+				max = math.Max(max, responsibilities[j][k])
+			}
+			sum := 0.
+			for k := range responsibilities[j] {
+				responsibilities[j][k] = math.Exp(responsibilities[j][k] - max)
+				sum += responsibilities[j][k]
+			}
+			for k := range responsibilities[j] {
+				responsibilities[j][k] /= sum
+			}
+			logLikelihood += max + math.Log(sum)
+		}
+		// M step, components without responsibility keep their parameters
+		for k := range mixture.Weights {
+			nk := 0.
+			for j := range draws {
+				nk += responsibilities[j][k]
+			}
+			if nk < 1e-10 {
+				continue
+			}
+			mixture.Weights[k] = nk / float64(n)
+			for i := range mixture.Means[k] {
+				m := 0.
+				for j, x := range draws {
+					m += responsibilities[j][k] * x[i] / nk
+				}
+				v := 0.
+				for j, x := range draws {
+					v += responsibilities[j][k] * (x[i] - m) * (x[i] - m) / nk
+				}
+				mixture.Means[k][i], mixture.Variances[k][i] = m, math.Max(v, floor[i])
+			}
+		}
+		if logLikelihood-previous < 1e-8*float64(n) {
+			break
+		}
+		previous = logLikelihood
+	}
+	return newMixture(mixture.Weights, mixture.Means, mixture.Variances), nil
+}
+
+func squaredDistance(x, y []float64) float64 {
+	d := 0.
+	for i := range x {
+		d += (x[i] - y[i]) * (x[i] - y[i])
+	}
+	return d
+}
+
+// logNormalDiagonal is the log density of a Gaussian with diagonal covariance
+func logNormalDiagonal(x, mean, variance []float64) float64 {
+	logDensity := 0.
+	for i, xi := range x {
+		d := xi - mean[i]
+		logDensity -= 0.5 * (d*d/variance[i] + math.Log(2*math.Pi*variance[i]))
+	}
+	return logDensity
+}
+
+// ImportanceSampling estimates the evidence from n draws of the proposal,
+// with the standard error of the log of the mean weight by the delta method
+func ImportanceSampling(logTarget func([]float64) float64, proposal Mixture, n int, rng *rand.Rand) Evidence {
+	logWeights := make([]float64, n)
+	for i := range logWeights {
+		x := proposal.Sample(rng)
+		logWeights[i] = logTarget(x) - proposal.LogDensity(x)
+	}
+	max := math.Inf(-1)
+	for _, l := range logWeights {
+		max = math.Max(max, l)
+	}
+	weights := make([]float64, n)
+	for i, l := range logWeights {
+		weights[i] = math.Exp(l - max)
+	}
+	mean, variance := meanAndVariance(weights)
+	return Evidence{
+		Method:        "importance sampling",
+		LogZ:          max + math.Log(mean),
+		StandardError: math.Sqrt(variance/float64(n)) / mean,
+	}
+}
+
+// BridgeSampling estimates the evidence by the optimal bridge function of Meng and Wong (1996),
+// iterated to convergence, between draws of the target indexed by [particle][draw][dim] and n
+// draws of the proposal. The proposal should be fitted to other draws than those given here.
+// The standard error approximates the relative mean squared error of Fruhwirth-Schnatter (2004),
+// with the autocorrelation of the draws of the target accounted for by their effective sample size.
+func BridgeSampling(logTarget func([]float64) float64, proposal Mixture, draws [][][]float64, n int, rng *rand.Rand) (Evidence, error) {
+	// log ratios of the target to the proposal at draws of the target and of the proposal
+	targetRatios := make([][]float64, len(draws))
+	numTarget := 0
+	for i, chain := range draws {
+		targetRatios[i] = make([]float64, len(chain))
+		for j, x := range chain {
+			targetRatios[i][j] = logTarget(x) - proposal.LogDensity(x)
+		}
+		numTarget += len(chain)
+	}
+	if numTarget == 0 {
+		return Evidence{}, fmt.Errorf("bridge sampling: no draws of the target")
+	}
+	proposalRatios := make([]float64, n)
+	for i := range proposalRatios {
+		y := proposal.Sample(rng)
+		proposalRatios[i] = logTarget(y) - proposal.LogDensity(y)
+	}
+	s1 := float64(numTarget) / float64(numTarget+n)
+	s2 := 1 - s1
+	logS1, logS2 := math.Log(s1), math.Log(s2)
+
+	// start from the importance sampling estimate
+	logZ := logMeanExp(proposalRatios)
+	for iteration := 0; iteration != 1000; iteration++ {
+		numerator := make([]float64, n)
+		for i, l := range proposalRatios {
+			numerator[i] = l - logAddExp(logS1+l, logS2+logZ)
+		}
+		denominator := make([]float64, 0, numTarget)
+		for _, chain := range targetRatios {
+			for _, l := range chain {
+				denominator = append(denominator, -logAddExp(logS1+l, logS2+logZ))
+			}
+		}
+		next := logMeanExp(numerator) - logMeanExp(denominator)
+		if math.IsNaN(next) {
+			return Evidence{}, fmt.Errorf("bridge sampling: estimate is not a number")
+		}
+		converged := math.Abs(next-logZ) < 1e-10
+		logZ = next
+		if converged {
+			break
+		}
+	}
+
+	// f1 at the draws of the proposal, f2 at the draws of the target, both bounded
+	f1 := make([]float64, n)
+	for i, l := range proposalRatios {
+		f1[i] = 1 / (s1 + s2*math.Exp(logZ-l))
+	}
+	f2 := make([][][]float64, len(draws))
+	pooled := make([]float64, 0, numTarget)
+	for i, chain := range targetRatios {
+		f2[i] = make([][]float64, len(chain))
+		for j, l := range chain {
+			f := 1 / (s1*math.Exp(l-logZ) + s2)
+			f2[i][j] = []float64{f}
+			pooled = append(pooled, f)
+		}
+	}
+	mean1, variance1 := meanAndVariance(f1)
+	mean2, variance2 := meanAndVariance(pooled)
+	ess := float64(numTarget)
+	if e := bmc.EffectiveSampleSize(f2); len(e) == 1 && e[0] > 0 && !math.IsNaN(e[0]) {
+		ess = math.Min(e[0], ess)
+	}
+	relativeError := variance1/(mean1*mean1)/float64(n) + variance2/(mean2*mean2)/ess
+	return Evidence{Method: "bridge sampling", LogZ: logZ, StandardError: math.Sqrt(relativeError)}, nil
+}
+
+// Rung holds draws of one tempered density of a temperature ladder, indexed by [particle][draw][dim]
+type Rung struct {
+	// Beta is the inverse temperature of the tempered density q^(1-Beta) p^Beta
+	// between a normalized reference q and the target p
+	Beta  float64
+	Draws [][][]float64
+}
+
+// TemperedTarget returns the log density (1-beta) log q + beta log p with autodiff gradients.
+// Sample it with LogDensity.
+func TemperedTarget(dist Distribution, reference Mixture, beta float64) bmc.Target {
+	return func(x ad.Vector) ad.Scalar {
+		return ads.Add(ads.Mul(ad.NewReal(1-beta), reference.logDensityOf(x)), ads.Mul(ad.NewReal(beta), ads.Log(dist(x))))
+	}
+}
+
+// logDensityOf is LogDensity on autodiff vectors
+func (mixture Mixture) logDensityOf(x ad.Vector) ad.Scalar {
+	dim := mixture.Dim()
+	logTerms := make([]ad.Scalar, len(mixture.Weights))
+	max := math.Inf(-1)
+	for k, w := range mixture.Weights {
+		precision := make([]float64, dim*dim)
+		logNormalizer := math.Log(w)
+		for i, v := range mixture.Variances[k] {
+			precision[i*dim+i] = 1 / v
+			logNormalizer -= 0.5 * math.Log(2*math.Pi*v)
+		}
+		d := ads.VsubV(x, ad.NewVector(ad.RealType, mixture.Means[k]))
+		dPd := ads.VdotV(d, ads.MdotV(ad.NewMatrix(ad.RealType, dim, dim, precision), d))
+		logTerms[k] = ads.Sub(ad.NewReal(logNormalizer), ads.Div(dPd, ad.NewReal(2)))
+		max = math.Max(max, logTerms[k].GetValue())
+	}
+	sum := ad.NewScalar(ad.RealType, 0)
+	for _, logTerm := range logTerms {
+		sum = ads.Add(sum, ads.Exp(ads.Sub(logTerm, ad.NewReal(max))))
+	}
+	return ads.Add(ad.NewReal(max), ads.Log(sum))
+}
+
+// SampleLadder samples the tempered densities tempered(beta), such as TemperedTarget, of the inverse
+// temperatures betas with BMC, using BMC as a template, and keeps numDraws draws of every particle after
+// the warmup of its step size. The rung at beta 0 is the reference itself and is drawn exactly. Every
+// run starts from a draw of the reference.
+func SampleLadder(
+	BMC bmc.BrownianMonteCarlo,
+	tempered func(beta float64) bmc.Target,
+	reference Mixture,
+	betas []float64,
+	numDraws int,
+	rng *rand.Rand,
+) ([]Rung, error) {
+	ladder := make([]Rung, len(betas))
+	for r, beta := range betas {
+		ladder[r] = Rung{Beta: beta, Draws: make([][][]float64, BMC.NumParticles)}
+		if beta == 0 {
+			for i := range ladder[r].Draws {
+				for k := 0; k != numDraws; k++ {
+					ladder[r].Draws[i] = append(ladder[r].Draws[i], reference.Sample(rng))
+				}
+			}
+			continue
+		}
+		rung := BMC
+		rung.LogDensity = true
+		rung.Radius = append([]float64{}, BMC.Radius...)
+		sample := make(chan bmc.Sample)
+		collidedSample := make(chan bmc.Sample, 1)
+		if err := rung.Sample(tempered(beta), ad.NewVector(ad.RealType, reference.Sample(rng)), sample, collidedSample); err != nil {
+			return nil, err
+		}
+		for received := 0; received != (rung.MaxAdapt+numDraws)*rung.NumParticles; received++ {
+			s, ok := <-sample
+			if !ok {
+				return nil, rung.Err()
+			}
+			if !s.Warmup {
+				ladder[r].Draws[s.ID] = append(ladder[r].Draws[s.ID], s.X)
+			}
+		}
+		rung.Stop()
+	}
+	return ladder, nil
+}
+
+// PowerLadder returns n inverse temperatures (k/(n-1))^5 from 0 to 1, dense near the reference
+func PowerLadder(n int) []float64 {
+	betas := make([]float64, n)
+	for k := range betas {
+		betas[k] = math.Pow(float64(k)/float64(n-1), 5)
+	}
+	return betas
+}
+
+// ThermodynamicIntegration estimates the evidence by integrating the mean of log p - log q over
+// the temperature ladder with the trapezoidal rule. The ladder must be ordered from beta 0 to 1.
+// The standard error combines the Monte Carlo errors of the rungs, not the discretization error.
+func ThermodynamicIntegration(ladder []Rung, logTarget func([]float64) float64, reference Mixture) (Evidence, error) {
+	if len(ladder) < 2 || ladder[0].Beta != 0 || ladder[len(ladder)-1].Beta != 1 {
+		return Evidence{}, fmt.Errorf("thermodynamic integration: ladder must run from beta 0 to 1")
+	}
+	means, variances := make([]float64, len(ladder)), make([]float64, len(ladder))
+	for r, rung := range ladder {
+		if r > 0 && rung.Beta <= ladder[r-1].Beta {
+			return Evidence{}, fmt.Errorf("thermodynamic integration: beta %v after %v", rung.Beta, ladder[r-1].Beta)
+		}
+		energies := make([][][]float64, len(rung.Draws))
+		pooled := make([]float64, 0)
+		for i, chain := range rung.Draws {
+			energies[i] = make([][]float64, len(chain))
+			for j, x := range chain {
+				u := logTarget(x) - reference.LogDensity(x)
+				energies[i][j] = []float64{u}
+				pooled = append(pooled, u)
+			}
+		}
+		if len(pooled) == 0 {
+			return Evidence{}, fmt.Errorf("thermodynamic integration: no draws at beta %v", rung.Beta)
+		}
+		mean, variance := meanAndVariance(pooled)
+		ess := float64(len(pooled))
+		if e := bmc.EffectiveSampleSize(energies); len(e) == 1 && e[0] > 0 && !math.IsNaN(e[0]) {
+			ess = math.Min(e[0], ess)
+		}
+		means[r], variances[r] = mean, variance/ess
+	}
+	logZ, variance := 0., 0.
+	for r := 1; r != len(ladder); r++ {
+		h := ladder[r].Beta - ladder[r-1].Beta
+		logZ += h * (means[r] + means[r-1]) / 2
+	}
+	for r := range ladder {
+		// trapezoidal weight of rung r
+		w := 0.
+		if r > 0 {
+			w += (ladder[r].Beta - ladder[r-1].Beta) / 2
+		}
+		if r < len(ladder)-1 {
+			w += (ladder[r+1].Beta - ladder[r].Beta) / 2
+		}
+		variance += w * w * variances[r]
+	}
+	return Evidence{Method: "thermodynamic integration", LogZ: logZ, StandardError: math.Sqrt(variance)}, nil
+}
+
+// logAddExp is log(exp(a) + exp(b)) without overflow
+func logAddExp(a, b float64) float64 {
+	if a < b {
+		a, b = b, a
+	}
+	if math.IsInf(a, -1) {
+		return a
+	}
+	return a + math.Log1p(math.Exp(b-a))
+}
+
+// logMeanExp is the log of the mean of exp(xs) without overflow
+func logMeanExp(xs []float64) float64 {
+	max := math.Inf(-1)
+	for _, x := range xs {
+		max = math.Max(max, x)
+	}
+	sum := 0.
+	for _, x := range xs {
+		sum += math.Exp(x - max)
+	}
+	return max + math.Log(sum/float64(len(xs)))
+}
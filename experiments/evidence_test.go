@@ -0,0 +1,104 @@
+package experiments
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func exactDraws(mixture Mixture, numChains, numDraws int, rng *rand.Rand) [][][]float64 {
+	draws := make([][][]float64, numChains)
+	for i := range draws {
+		for k := 0; k != numDraws; k++ {
+			draws[i] = append(draws[i], mixture.Sample(rng))
+		}
+	}
+	return draws
+}
+
+// temperedDraws draws exactly from the density proportional to q^(1-beta) p^beta of a reference q
+// and a target p by rejection from (1-beta) q + beta p, which bounds it
+func temperedDraws(target, reference Mixture, beta float64, numChains, numDraws int, rng *rand.Rand) [][][]float64 {
+	draws := make([][][]float64, numChains)
+	for i := range draws {
+		for len(draws[i]) != numDraws {
+			x := target.Sample(rng)
+			if rng.Float64() >= beta {
+				x = reference.Sample(rng)
+			}
+			logQ, logP := reference.LogDensity(x), target.LogDensity(x)
+			logTempered := (1-beta)*logQ + beta*logP
+			if math.Log(rng.Float64()) < logTempered-logAddExp(math.Log(1-beta)+logQ, math.Log(beta)+logP) {
+				draws[i] = append(draws[i], x)
+			}
+		}
+	}
+	return draws
+}
+
+func TestFitMixtureSeparatedComponents(t *testing.T) {
+	mixture, _ := GetMixture("AsymUnbalMOG2d")
+	rng := rand.New(rand.NewSource(1))
+	draws := exactDraws(mixture, 1, 6000, rng)[0]
+	fitted, err := FitMixture(draws, 3, 200, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, mean := range mixture.Means {
+		nearest := 0
+		for j := range fitted.Means {
+			if squaredDistance(fitted.Means[j], mean) < squaredDistance(fitted.Means[nearest], mean) {
+				nearest = j
+			}
+		}
+		if d := math.Sqrt(squaredDistance(fitted.Means[nearest], mean)); d > 0.15 {
+			t.Errorf("component %d: mean %v, want %v", k, fitted.Means[nearest], mean)
+		}
+		if w := fitted.Weights[nearest]; math.Abs(w-mixture.Weights[k]) > 0.03 {
+			t.Errorf("component %d: weight %v, want %v", k, w, mixture.Weights[k])
+		}
+		for i, v := range fitted.Variances[nearest] {
+			if want := mixture.Variances[k][i]; math.Abs(v/want-1) > 0.15 {
+				t.Errorf("component %d: variance %v, want %v", k, v, want)
+			}
+		}
+	}
+}
+
+func TestEvidenceOfMixture(t *testing.T) {
+	const name = "AsymMOG2d"
+	logZ, ok := LogNormalizingConstant(name)
+	if !ok {
+		t.Fatalf("no normalizing constant of %s", name)
+	}
+	logTarget := LogDensityOf(GetDistribution(name))
+	mixture, _ := GetMixture(name)
+	rng := rand.New(rand.NewSource(1))
+	proposal, err := FitMixture(exactDraws(mixture, 1, 4000, rng)[0], 3, 200, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	estimates := []Evidence{ImportanceSampling(logTarget, proposal, 10000, rng)}
+	bridge, err := BridgeSampling(logTarget, proposal, exactDraws(mixture, 4, 1000, rng), 10000, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	estimates = append(estimates, bridge)
+	betas := PowerLadder(6)
+	ladder := make([]Rung, len(betas))
+	for r, beta := range betas {
+		ladder[r] = Rung{Beta: beta, Draws: temperedDraws(mixture, proposal, beta, 4, 500, rng)}
+	}
+	ti, err := ThermodynamicIntegration(ladder, logTarget, proposal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	estimates = append(estimates, ti)
+
+	for _, e := range estimates {
+		if !(e.StandardError > 0) || math.Abs(e.LogZ-logZ) > 4*e.StandardError {
+			t.Errorf("%s: log Z %v with standard error %v, want %v", e.Method, e.LogZ, e.StandardError, logZ)
+		}
+	}
+}
@@ -0,0 +1,669 @@
+package experiments
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+	ad "github.com/pbenner/autodiff"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/palette"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+)
+
+// Run is a sampling run loaded from a manifest and its samples file
+type Run struct {
+	Manifest Manifest
+	// Draws are indexed by [particle][draw][dim]
+	Draws [][][]float64
+	// Generated are the generated quantities of the draws after warmup, indexed by [particle][draw][quantity]
+	Generated [][][]float64
+	// Dims are the selected dimensions to plot and tabulate, all if nil
+	Dims []int
+	// GeneratedDims are the selected generated quantities to tabulate, all if nil
+	GeneratedDims []int
+	// Weighting pools the particles in estimates of moments, ESS weights by default
+	Weighting Weighting
+}
+
+// LoadRun reads a manifest and its samples, dropping the first warmup fraction of the draws of every particle
+func LoadRun(manifestPath string, warmup float64) (Run, error) {
+	file, err := os.Open(manifestPath)
+	if err != nil {
+		return Run{}, err
+	}
+	defer file.Close()
+	var manifest Manifest
+	if err := json.NewDecoder(file).Decode(&manifest); err != nil {
+		return Run{}, fmt.Errorf("%s: %v", manifestPath, err)
+	}
+	if manifest.Dim <= 0 {
+		return Run{}, fmt.Errorf("%s: dimension is missing", manifestPath)
+	}
+	samples, err := ReadCSV(manifest.Samples, manifest.Dim)
+	if err != nil {
+		return Run{}, err
+	}
+	run := Run{Manifest: manifest, Draws: make([][][]float64, manifest.NumParticles)}
+	generated := make([][][]float64, manifest.NumParticles)
+	for _, s := range samples {
+		if s.ID < 0 || s.ID >= manifest.NumParticles {
+			return Run{}, fmt.Errorf("%s: particle %d out of range", manifest.Samples, s.ID)
+		}
+		run.Draws[s.ID] = append(run.Draws[s.ID], s.X)
+		generated[s.ID] = append(generated[s.ID], s.Generated)
+	}
+	for i, draws := range run.Draws {
+		first := int(warmup * float64(len(draws)))
+		run.Draws[i] = draws[first:]
+		generated[i] = generated[i][first:]
+	}
+	if manifest.NumGenerated > 0 {
+		// draws of the sampler's own warmup have no generated quantities
+		run.Generated = make([][][]float64, manifest.NumParticles)
+		for i := range generated {
+			for _, g := range generated[i] {
+				if len(g) == manifest.NumGenerated {
+					run.Generated[i] = append(run.Generated[i], g)
+				}
+			}
+		}
+	}
+	return run, nil
+}
+
+// Select restricts plots and tables to parameters or generated quantities given by label,
+// such as beta[2], or by the name of a block, such as beta for all of beta[1], beta[2], ...
+func (run *Run) Select(selection []string) error {
+	labels, generatedLabels := run.Manifest.Labels(), run.Manifest.GeneratedLabels()
+	run.Dims, run.GeneratedDims = []int{}, []int{}
+	for _, name := range selection {
+		dims, generatedDims := matchLabels(labels, name), matchLabels(generatedLabels, name)
+		if len(dims) == 0 && len(generatedDims) == 0 {
+			return fmt.Errorf("no parameter %q in %v", name, append(labels, generatedLabels...))
+		}
+		run.Dims = mergeDims(run.Dims, dims)
+		run.GeneratedDims = mergeDims(run.GeneratedDims, generatedDims)
+	}
+	return nil
+}
+
+// matchLabels returns the indices of the labels equal to name or of a block named name
+func matchLabels(labels []string, name string) []int {
+	dims := make([]int, 0)
+	for d, label := range labels {
+		if label == name || bmc.BaseName(label) == name {
+			dims = append(dims, d)
+		}
+	}
+	return dims
+}
+
+// mergeDims appends the dimensions that are not selected yet
+func mergeDims(selected, dims []int) []int {
+	for _, d := range dims {
+		found := false
+		for _, e := range selected {
+			found = found || e == d
+		}
+		if !found {
+			selected = append(selected, d)
+		}
+	}
+	return selected
+}
+
+// Selected returns Dims, or all dimensions if none are selected
+func (run Run) Selected() []int {
+	if run.Dims != nil {
+		return run.Dims
+	}
+	dims := make([]int, run.Manifest.Dim)
+	for d := range dims {
+		dims[d] = d
+	}
+	return dims
+}
+
+// SelectedGenerated returns GeneratedDims, or all generated quantities if none are selected
+func (run Run) SelectedGenerated() []int {
+	if run.GeneratedDims != nil {
+		return run.GeneratedDims
+	}
+	dims := make([]int, run.Manifest.NumGenerated)
+	for d := range dims {
+		dims[d] = d
+	}
+	return dims
+}
+
+// label returns the name of a dimension
+func (run Run) label(d int) string {
+	return run.Manifest.Labels()[d]
+}
+
+// PlotAll saves every plot of a run as <dir>/<name>_<kind>.png, where name is the name of the samples file
+func (run Run) PlotAll(dir string) error {
+	name := strings.TrimSuffix(filepath.Base(run.Manifest.Samples), ".csv")
+	plots := []struct {
+		kind string
+		plot func(path string) error
+	}{
+		{"pairs", run.PlotPairs},
+		{"marginals", run.PlotMarginals},
+		{"contours", run.PlotContours},
+		{"moments", run.PlotMoments},
+		{"traces", run.PlotTraces},
+		{"autocorrelations", func(path string) error { return run.PlotAutocorrelations(path, 50) }},
+	}
+	for _, p := range plots {
+		if err := p.plot(filepath.Join(dir, name+"_"+p.kind+".png")); err != nil {
+			return fmt.Errorf("%s plot: %v", p.kind, err)
+		}
+	}
+	return nil
+}
+
+// PlotPairs plots a grid of pairwise scatters with marginal histograms on the diagonal
+func (run Run) PlotPairs(path string) error {
+	dims := run.Selected()
+	grid := make([][]*plot.Plot, len(dims))
+	for i := range grid {
+		grid[i] = make([]*plot.Plot, len(dims))
+		for j := range grid[i] {
+			var err error
+			if i == j {
+				grid[i][j], err = run.marginalPlot(dims[i])
+			} else {
+				grid[i][j], err = run.scatterPlot(dims[j], dims[i])
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return saveGrid(grid, 3*vg.Inch, path)
+}
+
+// PlotMarginals plots a histogram of every dimension, with the true marginal density if it is known
+func (run Run) PlotMarginals(path string) error {
+	dims := run.Selected()
+	panels := make([]*plot.Plot, len(dims))
+	for k, d := range dims {
+		var err error
+		if panels[k], err = run.marginalPlot(d); err != nil {
+			return err
+		}
+	}
+	grid, err := arrange(panels)
+	if err != nil {
+		return err
+	}
+	return saveGrid(grid, 3*vg.Inch, path)
+}
+
+// PlotContours plots kernel density contours of the first two dimensions to plot
+// over the contours of the true density
+func (run Run) PlotContours(path string) error {
+	dims := run.Selected()
+	if len(dims) < 2 {
+		return fmt.Errorf("contours need at least two dimensions")
+	}
+	i, j := dims[0], dims[1]
+	xs, ys := run.pooled(i), run.pooled(j)
+	xGrid, yGrid := linspace(xs, 60), linspace(ys, 60)
+	p, err := newPlot(fmt.Sprintf("Density of %s and %s", run.label(i), run.label(j)), run.label(i), run.label(j))
+	if err != nil {
+		return err
+	}
+	if density := run.trueDensity2d(i, j); density != nil {
+		truth := evaluateGrid(xGrid, yGrid, density)
+		contour := plotter.NewContour(truth, levels(truth), singleColor{color.Gray{Y: 128}})
+		p.Add(contour)
+		p.Legend.Add("Ground truth", contour)
+	}
+	kde := evaluateGrid(xGrid, yGrid, kernelDensity2d(thin(xs, 2000), thin(ys, 2000)))
+	contour := plotter.NewContour(kde, levels(kde), palette.Heat(5, 1))
+	p.Add(contour)
+	p.Legend.Add("KDE", contour)
+	return p.Save(6*vg.Inch, 6*vg.Inch, path)
+}
+
+// PlotMoments plots the running first moments of every dimension to plot and the running
+// cross moment of the first two, of every particle and pooled over the particles with the
+// weights of run.Weighting, with the ground truth if it is known
+func (run Run) PlotMoments(path string) error {
+	moments := run.Moments()
+	panels := make([]*plot.Plot, 0, len(moments))
+	for _, m := range moments {
+		p, err := newPlot(m.Name, "Iterations", m.Name)
+		if err != nil {
+			return err
+		}
+		runningMoments := make([][]float64, len(run.Draws))
+		for i, draws := range run.Draws {
+			runningMoments[i] = runningMean(draws, m.Moment)
+			line, err := lineOf(runningMoments[i], particleColor(i, len(run.Draws)), false)
+			if err != nil {
+				return err
+			}
+			p.Add(line)
+			p.Legend.Add(fmt.Sprintf("Particle %d", i+1), line)
+		}
+		line, err := lineOf(runningPooledMean(run.Draws, runningMoments, m.Moment, run.Weighting, 50), color.Black, true)
+		if err != nil {
+			return err
+		}
+		p.Add(line)
+		p.Legend.Add(fmt.Sprintf("Mean (%s weights)", run.Weighting), line)
+		if !math.IsNaN(m.Truth) {
+			constant := make([]float64, len(runningMoments[0]))
+			for t := range constant {
+				constant[t] = m.Truth
+			}
+			line, err := lineOf(constant, color.Gray{Y: 128}, true)
+			if err != nil {
+				return err
+			}
+			p.Add(line)
+			p.Legend.Add("Ground truth", line)
+		}
+		panels = append(panels, p)
+	}
+	grid, err := arrange(panels)
+	if err != nil {
+		return err
+	}
+	return saveGrid(grid, 4*vg.Inch, path)
+}
+
+// PlotTraces plots the draws of every particle against iterations
+func (run Run) PlotTraces(path string) error {
+	dims := run.Selected()
+	panels := make([]*plot.Plot, len(dims))
+	for k, d := range dims {
+		p, err := newPlot(fmt.Sprintf("Trace of %s", run.label(d)), "Iterations", run.label(d))
+		if err != nil {
+			return err
+		}
+		for i, draws := range run.Draws {
+			trace := make([]float64, len(draws))
+			for t, x := range draws {
+				trace[t] = x[d]
+			}
+			line, err := lineOf(trace, particleColor(i, len(run.Draws)), false)
+			if err != nil {
+				return err
+			}
+			p.Add(line)
+		}
+		panels[k] = p
+	}
+	grid, err := arrange(panels)
+	if err != nil {
+		return err
+	}
+	return saveGrid(grid, 4*vg.Inch, path)
+}
+
+// PlotAutocorrelations plots the autocorrelation of every particle up to maxLag
+func (run Run) PlotAutocorrelations(path string, maxLag int) error {
+	dims := run.Selected()
+	panels := make([]*plot.Plot, len(dims))
+	for k, d := range dims {
+		p, err := newPlot(fmt.Sprintf("Autocorrelation of %s", run.label(d)), "Lag", "ACF")
+		if err != nil {
+			return err
+		}
+		for i, draws := range run.Draws {
+			xs := make([]float64, len(draws))
+			for t, x := range draws {
+				xs[t] = x[d]
+			}
+			line, err := lineOf(autocorrelation(xs, maxLag), particleColor(i, len(run.Draws)), false)
+			if err != nil {
+				return err
+			}
+			p.Add(line)
+		}
+		panels[k] = p
+	}
+	grid, err := arrange(panels)
+	if err != nil {
+		return err
+	}
+	return saveGrid(grid, 4*vg.Inch, path)
+}
+
+// marginalPlot is a normalized histogram of the pooled draws of one dimension
+func (run Run) marginalPlot(d int) (*plot.Plot, error) {
+	p, err := newPlot(run.label(d), "", "")
+	if err != nil {
+		return nil, err
+	}
+	hist, err := plotter.NewHist(plotter.Values(run.pooled(d)), 50)
+	if err != nil {
+		return nil, err
+	}
+	hist.Normalize(1)
+	hist.FillColor = color.Gray{Y: 200}
+	p.Add(hist)
+	if mixture, ok := GetMixture(run.Manifest.Target); ok {
+		density := plotter.NewFunction(mixture.MarginalDensity(d))
+		density.Samples = 200
+		density.LineStyle.Color = color.RGBA{R: 255, A: 255}
+		p.Add(density)
+	}
+	return p, nil
+}
+
+// scatterPlot is a scatter of two dimensions colored by particle
+func (run Run) scatterPlot(i, j int) (*plot.Plot, error) {
+	p, err := newPlot(fmt.Sprintf("%s vs. %s", run.label(i), run.label(j)), "", "")
+	if err != nil {
+		return nil, err
+	}
+	for id, draws := range run.Draws {
+		data := make(plotter.XYs, 0, len(draws))
+		for _, x := range thinDraws(draws, 2000) {
+			data = append(data, struct{ X, Y float64 }{x[i], x[j]})
+		}
+		s, err := plotter.NewScatter(data)
+		if err != nil {
+			return nil, err
+		}
+		s.GlyphStyle.Shape = draw.CircleGlyph{}
+		s.GlyphStyle.Radius = vg.Points(1)
+		s.GlyphStyle.Color = particleColor(id, len(run.Draws))
+		p.Add(s)
+	}
+	return p, nil
+}
+
+// trueDensity2d is the density of dimensions i and j if it is known
+func (run Run) trueDensity2d(i, j int) func(x, y float64) float64 {
+	if mixture, ok := GetMixture(run.Manifest.Target); ok {
+		return mixture.MarginalDensity2d(i, j)
+	}
+	if target := GetDistribution(run.Manifest.Target); target != nil && run.Manifest.Dim == 2 && i == 0 && j == 1 {
+		return func(x, y float64) float64 {
+			return target(ad.NewVector(ad.RealType, []float64{x, y})).GetValue()
+		}
+	}
+	return nil
+}
+
+// pooled returns the draws of one dimension over all particles
+func (run Run) pooled(d int) []float64 {
+	xs := make([]float64, 0)
+	for _, draws := range run.Draws {
+		for _, x := range draws {
+			xs = append(xs, x[d])
+		}
+	}
+	return xs
+}
+
+func newPlot(title, xLabel, yLabel string) (*plot.Plot, error) {
+	p, err := plot.New()
+	if err != nil {
+		return nil, err
+	}
+	p.Title.Text = title
+	p.X.Label.Text = xLabel
+	p.Y.Label.Text = yLabel
+	p.Legend.Top = true
+	return p, nil
+}
+
+// particleColor gives every particle its own color, the palette of the Python scripts
+func particleColor(id, numParticles int) color.Color {
+	colors := []color.RGBA{
+		{R: 31, G: 119, B: 180, A: 255},
+		{R: 255, G: 127, B: 14, A: 255},
+		{R: 44, G: 160, B: 44, A: 255},
+		{R: 214, G: 39, B: 40, A: 255},
+		{R: 148, G: 103, B: 189, A: 255},
+		{R: 140, G: 86, B: 75, A: 255},
+		{R: 227, G: 119, B: 194, A: 255},
+		{R: 127, G: 127, B: 127, A: 255},
+		{R: 188, G: 189, B: 34, A: 255},
+		{R: 23, G: 190, B: 207, A: 255},
+	}
+	if numParticles > len(colors) {
+		return pointColor(id, numParticles)
+	}
+	return colors[id]
+}
+
+// lineOf plots values against their index
+func lineOf(values []float64, c color.Color, dashed bool) (*plotter.Line, error) {
+	data := make(plotter.XYs, len(values))
+	for t, v := range values {
+		data[t].X, data[t].Y = float64(t), v
+	}
+	line, err := plotter.NewLine(data)
+	if err != nil {
+		return nil, err
+	}
+	line.LineStyle.Color = c
+	if dashed {
+		line.LineStyle.Dashes = []vg.Length{vg.Points(4), vg.Points(2)}
+	}
+	return line, nil
+}
+
+// arrange lays out panels in rows of at most four, empty panels fill the last row
+func arrange(panels []*plot.Plot) ([][]*plot.Plot, error) {
+	cols := len(panels)
+	if cols > 4 {
+		cols = 4
+	}
+	grid := make([][]*plot.Plot, 0)
+	for start := 0; start < len(panels); start += cols {
+		row := make([]*plot.Plot, cols)
+		for j := range row {
+			if start+j < len(panels) {
+				row[j] = panels[start+j]
+			} else {
+				var err error
+				if row[j], err = plot.New(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		grid = append(grid, row)
+	}
+	return grid, nil
+}
+
+// saveGrid draws a grid of plots into one PNG image
+func saveGrid(grid [][]*plot.Plot, cellSize vg.Length, path string) error {
+	rows, cols := len(grid), len(grid[0])
+	img := vgimg.New(vg.Length(cols)*cellSize, vg.Length(rows)*cellSize)
+	tiles := draw.Tiles{
+		Rows: rows,
+		Cols: cols,
+		PadX: vg.Points(8),
+		PadY: vg.Points(8),
+	}
+	canvases := plot.Align(grid, tiles, draw.New(img))
+	for i := range grid {
+		for j := range grid[i] {
+			grid[i][j].Draw(canvases[i][j])
+		}
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := (vgimg.PngCanvas{Canvas: img}).WriteTo(file); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// densityGrid holds a function evaluated on a grid for contour plots
+type densityGrid struct {
+	xs, ys []float64
+	z      [][]float64
+}
+
+func (g densityGrid) Dims() (c, r int)   { return len(g.xs), len(g.ys) }
+func (g densityGrid) Z(c, r int) float64 { return g.z[c][r] }
+func (g densityGrid) X(c int) float64    { return g.xs[c] }
+func (g densityGrid) Y(r int) float64    { return g.ys[r] }
+
+func evaluateGrid(xs, ys []float64, f func(x, y float64) float64) densityGrid {
+	g := densityGrid{xs: xs, ys: ys, z: make([][]float64, len(xs))}
+	for c, x := range xs {
+		g.z[c] = make([]float64, len(ys))
+		for r, y := range ys {
+			g.z[c][r] = f(x, y)
+		}
+	}
+	return g
+}
+
+// levels returns contour levels at fractions of the maximum of a grid
+func levels(g densityGrid) []float64 {
+	max := 0.
+	for _, column := range g.z {
+		for _, z := range column {
+			max = math.Max(max, z)
+		}
+	}
+	return []float64{0.05 * max, 0.1 * max, 0.25 * max, 0.5 * max, 0.75 * max}
+}
+
+// singleColor is a palette of one color
+type singleColor struct{ color.Color }
+
+func (c singleColor) Colors() []color.Color { return []color.Color{c.Color} }
+
+// kernelDensity2d is a Gaussian kernel density estimate with Scott's bandwidth
+func kernelDensity2d(xs, ys []float64) func(x, y float64) float64 {
+	n := float64(len(xs))
+	_, xVariance := meanAndVariance(xs)
+	_, yVariance := meanAndVariance(ys)
+	hx := math.Sqrt(xVariance) * math.Pow(n, -1./6)
+	hy := math.Sqrt(yVariance) * math.Pow(n, -1./6)
+	return func(x, y float64) float64 {
+		density := 0.
+		for i := range xs {
+			dx, dy := (x-xs[i])/hx, (y-ys[i])/hy
+			density += math.Exp(-0.5 * (dx*dx + dy*dy))
+		}
+		return density / (n * 2 * math.Pi * hx * hy)
+	}
+}
+
+func meanAndVariance(xs []float64) (mean, variance float64) {
+	for _, x := range xs {
+		mean += x / float64(len(xs))
+	}
+	for _, x := range xs {
+		variance += (x - mean) * (x - mean) / float64(len(xs)-1)
+	}
+	return mean, variance
+}
+
+// linspace returns n points spanning the range of xs
+func linspace(xs []float64, n int) []float64 {
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, x := range xs {
+		min, max = math.Min(min, x), math.Max(max, x)
+	}
+	return span(min, max, n)
+}
+
+// thin keeps at most n evenly spaced values
+func thin(xs []float64, n int) []float64 {
+	if len(xs) <= n {
+		return xs
+	}
+	thinned := make([]float64, n)
+	for i := range thinned {
+		thinned[i] = xs[i*len(xs)/n]
+	}
+	return thinned
+}
+
+func thinDraws(draws [][]float64, n int) [][]float64 {
+	if len(draws) <= n {
+		return draws
+	}
+	thinned := make([][]float64, n)
+	for i := range thinned {
+		thinned[i] = draws[i*len(draws)/n]
+	}
+	return thinned
+}
+
+// runningMean returns the mean of f over the first t+1 draws for every t
+func runningMean(draws [][]float64, f func([]float64) float64) []float64 {
+	means := make([]float64, len(draws))
+	sum := 0.
+	for t, x := range draws {
+		sum += f(x)
+		means[t] = sum / float64(t+1)
+	}
+	return means
+}
+
+// runningPooledMean pools the running means of the particles up to the length of the shortest.
+// The weights are those of weighting for the draws up to every one of numPoints evenly spaced
+// iterations, held until the next, so that the pooled mean at an iteration uses no later draws.
+func runningPooledMean(draws [][][]float64, runningMeans [][]float64, moment func([]float64) float64, weighting Weighting, numPoints int) []float64 {
+	n := -1
+	for _, s := range runningMeans {
+		if n < 0 || len(s) < n {
+			n = len(s)
+		}
+	}
+	stride := n / numPoints
+	if stride < 1 {
+		stride = 1
+	}
+	mean := make([]float64, n)
+	var weights []float64
+	for t := range mean {
+		if t%stride == 0 {
+			prefixes := make([][][]float64, len(draws))
+			for i := range draws {
+				prefixes[i] = draws[i][:t+1]
+			}
+			weights = Pool(prefixes, moment, weighting).Weights
+		}
+		for i, s := range runningMeans {
+			mean[t] += weights[i] * s[t]
+		}
+	}
+	return mean
+}
+
+// autocorrelation returns the sample autocorrelation up to maxLag
+func autocorrelation(xs []float64, maxLag int) []float64 {
+	if maxLag >= len(xs) {
+		maxLag = len(xs) - 1
+	}
+	mean, variance := meanAndVariance(xs)
+	acf := make([]float64, maxLag+1)
+	for lag := range acf {
+		sum := 0.
+		for t := 0; t+lag < len(xs); t++ {
+			sum += (xs[t] - mean) * (xs[t+lag] - mean)
+		}
+		acf[lag] = sum / float64(len(xs)-1) / variance
+	}
+	return acf
+}
@@ -0,0 +1,64 @@
+package experiments
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+)
+
+// GetGeneratedQuantities composes generated quantities of the draws of a target by name:
+//
+//	norm        the Euclidean norm of the draw
+//	mode        the mixture component with maximum responsibility, for mixture targets
+//	predictive  a replicate of the draw under unit Gaussian noise, one value per dimension
+//
+// It returns the quantities with their labels.
+func GetGeneratedQuantities(names []string, target string, dim int) (bmc.GeneratedQuantities, []string, error) {
+	generators := make([]func(x []float64, rng *rand.Rand) []float64, len(names))
+	labels := make([]string, 0)
+	for i, name := range names {
+		switch name {
+		case "norm":
+			generators[i] = func(x []float64, rng *rand.Rand) []float64 {
+				norm := 0.
+				for _, xi := range x {
+					norm += xi * xi
+				}
+				return []float64{math.Sqrt(norm)}
+			}
+			labels = append(labels, name)
+		case "mode":
+			mixture, ok := GetMixture(target)
+			if !ok {
+				return nil, nil, fmt.Errorf("generated quantity mode: %q is no mixture", target)
+			}
+			generators[i] = func(x []float64, rng *rand.Rand) []float64 {
+				return []float64{float64(mixture.Assign(x))}
+			}
+			labels = append(labels, name)
+		case "predictive":
+			generators[i] = func(x []float64, rng *rand.Rand) []float64 {
+				y := make([]float64, len(x))
+				for d, xd := range x {
+					y[d] = xd + rng.NormFloat64()
+				}
+				return y
+			}
+			for d := 1; d <= dim; d++ {
+				labels = append(labels, fmt.Sprintf("%s[%d]", name, d))
+			}
+		default:
+			return nil, nil, fmt.Errorf("unknown generated quantity %q", name)
+		}
+	}
+	generate := func(x []float64, rng *rand.Rand) []float64 {
+		quantities := make([]float64, 0, len(labels))
+		for _, generator := range generators {
+			quantities = append(quantities, generator(x, rng)...)
+		}
+		return quantities
+	}
+	return generate, labels, nil
+}
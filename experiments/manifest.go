@@ -0,0 +1,117 @@
+package experiments
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"time"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+)
+
+// Manifest describes a sampling run and is saved next to its samples
+type Manifest struct {
+	Sampler    string   `json:"sampler"`
+	Integrator string   `json:"integrator"`
+	Collision  string   `json:"collision"`
+	Target     string   `json:"target"`
+	Dim        int      `json:"dim"`
+	Names      []string `json:"names,omitempty"`
+	// NumGenerated is the number of generated quantities of every draw after warmup
+	NumGenerated   int       `json:"numGenerated,omitempty"`
+	GeneratedNames []string  `json:"generatedNames,omitempty"`
+	NumParticles   int       `json:"numParticles"`
+	NumSamples     int       `json:"numSamples"`
+	Radius         float64   `json:"radius"`
+	Asynchronous   bool      `json:"asynchronous"`
+	Masses         []float64 `json:"masses"`
+	Samples        string    `json:"samples"`
+	Energies       string    `json:"energies"`
+	// Error is why sampling stopped early, empty if the run is complete
+	Error string `json:"error,omitempty"`
+
+	// Acceptance and cost per particle
+	AcceptanceRate     []float64 `json:"acceptanceRate"`
+	NumEvaluations     []int     `json:"numEvaluations"`
+	EvaluationsPerDraw float64   `json:"evaluationsPerDraw"`
+	NumDivergences     []int     `json:"numDivergences"`
+
+	// Energy diagnostics per particle, null where undefined
+	EBFMI                         []*float64 `json:"ebfmi"`
+	MeanAbsCollisionKineticChange []*float64 `json:"meanAbsCollisionKineticChange"`
+	Elapsed                       float64    `json:"elapsedSeconds"`
+}
+
+// NewManifest collects the configuration and statistics of a finished run
+func NewManifest(BMC bmc.BrownianMonteCarlo, collision string, target string, numSamples int, elapsed time.Duration) Manifest {
+	manifest := Manifest{
+		Sampler:        getSamplerName(BMC.Sampler),
+		Integrator:     bmc.IntegratorName(BMC.Sampler),
+		Collision:      collision,
+		Target:         target,
+		NumParticles:   BMC.NumParticles,
+		NumSamples:     numSamples,
+		Radius:         BMC.InitialRadius,
+		Asynchronous:   BMC.Asynchronous,
+		Names:          BMC.Names,
+		GeneratedNames: BMC.GeneratedNames,
+		Masses:         make([]float64, BMC.NumParticles),
+		AcceptanceRate: make([]float64, BMC.NumParticles),
+		NumEvaluations: BMC.NumEvaluations,
+		NumDivergences: BMC.NumDivergences,
+		Elapsed:        elapsed.Seconds(),
+	}
+	totalEvaluations := 0
+	for i := 0; i != BMC.NumParticles; i++ {
+		manifest.Masses[i] = BMC.Masses[i].GetValue()
+		numAccepted := float64(BMC.NumAccepted[i])
+		numRejected := float64(BMC.NumRejected[i])
+		if numAccepted+numRejected > 0 {
+			manifest.AcceptanceRate[i] = numAccepted / (numAccepted + numRejected)
+		}
+		totalEvaluations += BMC.NumEvaluations[i]
+	}
+	if numSamples > 0 {
+		manifest.EvaluationsPerDraw = float64(totalEvaluations) / float64(numSamples)
+	}
+	return manifest
+}
+
+// Labels names every dimension of the run, X1, X2, ... if the parameters are anonymous
+func (manifest Manifest) Labels() []string {
+	return Labels(manifest.Names, manifest.Dim)
+}
+
+// GeneratedLabels names every generated quantity of the run, G1, G2, ... if they are anonymous
+func (manifest Manifest) GeneratedLabels() []string {
+	return labelsOf(manifest.GeneratedNames, manifest.NumGenerated, "G")
+}
+
+// Save writes the manifest as JSON
+func (manifest Manifest) Save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(manifest)
+}
+
+// nullable replaces values that JSON cannot represent with nil
+func nullable(xs []float64) []*float64 {
+	ys := make([]*float64, len(xs))
+	for i := range xs {
+		if !math.IsNaN(xs[i]) && !math.IsInf(xs[i], 0) {
+			ys[i] = &xs[i]
+		}
+	}
+	return ys
+}
+
+// SetEnergyDiagnostics adds energy diagnostics to the manifest
+func (manifest *Manifest) SetEnergyDiagnostics(diagnostics EnergyDiagnostics) {
+	manifest.EBFMI = nullable(diagnostics.EBFMI)
+	manifest.MeanAbsCollisionKineticChange = nullable(diagnostics.MeanAbsCollisionKineticChange)
+}
@@ -1,14 +1,57 @@
 package experiments
 
 import (
+	"math"
+	"sort"
+
 	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
 	ad "github.com/pbenner/autodiff"
 	ads "github.com/pbenner/autodiff/simple"
 )
 
-// KLDivKNN gives KL-divergence via k-nearest-neighbor distance
+// KLDivKNN gives KL-divergence via k-nearest-neighbor distance.
+// dist is unnormalized, so the divergence is offset by its log normalizing constant.
 func KLDivKNN(samples []bmc.Sample, dist Distribution) float64 {
-	return 0.
+	draws := make([][]float64, len(samples))
+	for i, s := range samples {
+		draws[i] = s.X
+	}
+	return KLDivergenceKNN(draws, func(x []float64) float64 {
+		return math.Log(dist(bmc.Float64ToVector(x)).GetValue())
+	}, 5)
+}
+
+// KLDivergenceKNN estimates KL(q || p) of the draws q against a normalized log density p.
+// The density of the draws is estimated from the distance to their k-th nearest distinct neighbors.
+func KLDivergenceKNN(draws [][]float64, logDensity func([]float64) float64, k int) float64 {
+	n := len(draws)
+	if n <= k {
+		return math.NaN()
+	}
+	d := float64(len(draws[0]))
+	// log volume of the d-dimensional unit ball
+	lgamma, _ := math.Lgamma(d/2 + 1)
+	logUnitBall := d/2*math.Log(math.Pi) - lgamma
+	kl := 0.
+	distances := make([]float64, n)
+	for _, x := range draws {
+		for j, y := range draws {
+			distances[j] = 0
+			for l := range x {
+				distances[j] += (x[l] - y[l]) * (x[l] - y[l])
+			}
+		}
+		sort.Float64s(distances)
+		// repeats of x from rejected transitions are not neighbors
+		first := sort.SearchFloat64s(distances, math.SmallestNonzeroFloat64)
+		if first+k-1 >= n {
+			return math.NaN()
+		}
+		rho := math.Sqrt(distances[first+k-1])
+		logQ := math.Log(float64(k)) - math.Log(float64(n-1)) - logUnitBall - d*math.Log(rho)
+		kl += (logQ - logDensity(x)) / float64(n)
+	}
+	return kl
 }
 
 func getMean(samples [][]float64) []float64 {
@@ -0,0 +1,327 @@
+package experiments
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+)
+
+// Mixture describes a Gaussian mixture with diagonal covariances by its components
+type Mixture struct {
+	Weights []float64
+	Means   [][]float64
+	// Variances are the diagonals of the covariances
+	Variances [][]float64
+}
+
+// GetMixture gets name of distribution and return its mixture components
+func GetMixture(name string) (Mixture, bool) {
+	asymMeans := [][]float64{{4.0*math.Sqrt(3.0) + 1., 1.}, {-4.0*math.Sqrt(3.0) - 1., 1.}, {3., -11.}}
+	asymVariances := [][]float64{{2., 2.}, {1., 1.}, {0.5, 0.5}}
+	switch name {
+	case "AsymMOG2d":
+		return newMixture([]float64{1, 1, 1}, asymMeans, asymVariances), true
+	case "AsymUnbalMOG2d":
+		return newMixture([]float64{1, 2, 3}, asymMeans, asymVariances), true
+	case "AsymUnbalRevMOG2d":
+		return newMixture([]float64{2, 1, 0.5}, asymMeans, asymVariances), true
+	case "AsymUnbalLevMOG2d":
+		return newMixture([]float64{4, 1, 0.25}, asymMeans, asymVariances), true
+	case "Sym16GM2d":
+		weights, means, variances := make([]float64, 16), make([][]float64, 16), make([][]float64, 16)
+		for i := range means {
+			weights[i] = 1
+			means[i] = []float64{float64(i/4) * 10, float64(i%4) * 10}
+			variances[i] = []float64{1, 1}
+		}
+		return newMixture(weights, means, variances), true
+	case "AsymMOG10d":
+		weights, means, variances := make([]float64, 3), make([][]float64, 3), make([][]float64, 3)
+		for i := range means {
+			weights[i] = 1
+			means[i] = make([]float64, 10)
+			variances[i] = make([]float64, 10)
+			for j := range means[i] {
+				means[i][j] = float64(i) * 5
+				variances[i][j] = 1
+			}
+		}
+		return newMixture(weights, means, variances), true
+	default:
+		return Mixture{}, false
+	}
+}
+
+// newMixture normalizes the weights
+func newMixture(weights []float64, means, variances [][]float64) Mixture {
+	total := 0.
+	for _, w := range weights {
+		total += w
+	}
+	normalized := make([]float64, len(weights))
+	for k, w := range weights {
+		normalized[k] = w / total
+	}
+	return Mixture{Weights: normalized, Means: means, Variances: variances}
+}
+
+// Dim returns the dimension of the mixture
+func (mixture Mixture) Dim() int {
+	return len(mixture.Means[0])
+}
+
+// Mean returns the mean of the mixture
+func (mixture Mixture) Mean() []float64 {
+	mean := make([]float64, mixture.Dim())
+	for k, w := range mixture.Weights {
+		for i, mu := range mixture.Means[k] {
+			mean[i] += w * mu
+		}
+	}
+	return mean
+}
+
+// CrossMoment returns E[x_i x_j] of the mixture
+func (mixture Mixture) CrossMoment(i, j int) float64 {
+	moment := 0.
+	for k, w := range mixture.Weights {
+		moment += w * mixture.Means[k][i] * mixture.Means[k][j]
+		if i == j {
+			moment += w * mixture.Variances[k][i]
+		}
+	}
+	return moment
+}
+
+// LogDensity returns the normalized log density of the mixture
+func (mixture Mixture) LogDensity(x []float64) float64 {
+	logTerms := make([]float64, len(mixture.Weights))
+	max := math.Inf(-1)
+	for k, w := range mixture.Weights {
+		logTerms[k] = math.Log(w)
+		for i, xi := range x {
+			v := mixture.Variances[k][i]
+			d := xi - mixture.Means[k][i]
+			logTerms[k] -= 0.5 * (d*d/v + math.Log(2*math.Pi*v))
+		}
+		max = math.Max(max, logTerms[k])
+	}
+	sum := 0.
+	for _, logTerm := range logTerms {
+		sum += math.Exp(logTerm - max)
+	}
+	return max + math.Log(sum)
+}
+
+// MarginalDensity returns the normalized marginal density of x_i
+func (mixture Mixture) MarginalDensity(i int) func(float64) float64 {
+	return func(x float64) float64 {
+		density := 0.
+		for k, w := range mixture.Weights {
+			v := mixture.Variances[k][i]
+			d := x - mixture.Means[k][i]
+			density += w * math.Exp(-0.5*d*d/v) / math.Sqrt(2*math.Pi*v)
+		}
+		return density
+	}
+}
+
+// MarginalDensity2d returns the normalized marginal density of (x_i, x_j)
+func (mixture Mixture) MarginalDensity2d(i, j int) func(x, y float64) float64 {
+	fi, fj := make([]func(float64) float64, len(mixture.Weights)), make([]func(float64) float64, len(mixture.Weights))
+	for k := range mixture.Weights {
+		component := Mixture{Weights: []float64{1}, Means: mixture.Means[k : k+1], Variances: mixture.Variances[k : k+1]}
+		fi[k], fj[k] = component.MarginalDensity(i), component.MarginalDensity(j)
+	}
+	return func(x, y float64) float64 {
+		density := 0.
+		for k, w := range mixture.Weights {
+			density += w * fi[k](x) * fj[k](y)
+		}
+		return density
+	}
+}
+
+// Assign returns the component with maximum responsibility for x
+func (mixture Mixture) Assign(x []float64) int {
+	best, bestLogResponsibility := 0, math.Inf(-1)
+	for k := range mixture.Weights {
+		logResponsibility := math.Log(mixture.Weights[k])
+		for i, xi := range x {
+			v := mixture.Variances[k][i]
+			d := xi - mixture.Means[k][i]
+			logResponsibility -= 0.5 * (d*d/v + math.Log(v))
+		}
+		if logResponsibility > bestLogResponsibility {
+			best, bestLogResponsibility = k, logResponsibility
+		}
+	}
+	return best
+}
+
+// ModeStatistics summarizes how draws visit the modes of a mixture
+type ModeStatistics struct {
+	NumDraws int
+	// Counts is the number of draws per mode and Occupancy the fraction of time per mode
+	Counts    []int
+	Occupancy []float64
+	// NumSwitches counts consecutive draws in different modes
+	NumSwitches int
+	// MeanDwellTime is the mean number of consecutive draws in one mode
+	MeanDwellTime float64
+	// WeightError is the occupancy minus the true weight per mode
+	WeightError []float64
+	// TotalVariation is the total variation distance between occupancy and true weights
+	TotalVariation float64
+	numDwells      int
+}
+
+// ModeOccupancy holds mode statistics per particle and over all particles
+type ModeOccupancy struct {
+	Particles []ModeStatistics
+	Overall   ModeStatistics
+}
+
+// ModeTracker assigns draws to modes as they arrive.
+// It is not safe for concurrent use.
+type ModeTracker struct {
+	Mixture   Mixture
+	particles []ModeStatistics
+	last      []int
+}
+
+// NewModeTracker creates a tracker for the draws of numParticles particles
+func NewModeTracker(mixture Mixture, numParticles int) *ModeTracker {
+	tracker := &ModeTracker{
+		Mixture:   mixture,
+		particles: make([]ModeStatistics, numParticles),
+		last:      make([]int, numParticles),
+	}
+	for i := range tracker.particles {
+		tracker.particles[i].Counts = make([]int, len(mixture.Weights))
+		tracker.last[i] = -1
+	}
+	return tracker
+}
+
+// Add assigns a draw to a mode
+func (tracker *ModeTracker) Add(s bmc.Sample) {
+	mode := tracker.Mixture.Assign(s.X)
+	statistics := &tracker.particles[s.ID]
+	statistics.NumDraws++
+	statistics.Counts[mode]++
+	if last := tracker.last[s.ID]; last != mode {
+		if last >= 0 {
+			statistics.NumSwitches++
+		}
+		statistics.numDwells++
+	}
+	tracker.last[s.ID] = mode
+}
+
+// Occupancy reports the statistics of the draws added so far
+func (tracker *ModeTracker) Occupancy() ModeOccupancy {
+	occupancy := ModeOccupancy{
+		Particles: make([]ModeStatistics, len(tracker.particles)),
+		Overall:   ModeStatistics{Counts: make([]int, len(tracker.Mixture.Weights))},
+	}
+	for i, statistics := range tracker.particles {
+		statistics.Counts = append([]int{}, statistics.Counts...)
+		occupancy.Particles[i] = tracker.Mixture.finish(statistics)
+		occupancy.Overall.NumDraws += statistics.NumDraws
+		occupancy.Overall.NumSwitches += statistics.NumSwitches
+		occupancy.Overall.numDwells += statistics.numDwells
+		for k, count := range statistics.Counts {
+			occupancy.Overall.Counts[k] += count
+		}
+	}
+	occupancy.Overall = tracker.Mixture.finish(occupancy.Overall)
+	return occupancy
+}
+
+// finish computes the occupancy, dwell time and weight errors from the counts
+func (mixture Mixture) finish(statistics ModeStatistics) ModeStatistics {
+	statistics.Occupancy = make([]float64, len(mixture.Weights))
+	statistics.WeightError = make([]float64, len(mixture.Weights))
+	statistics.TotalVariation = 0
+	for k, w := range mixture.Weights {
+		if statistics.NumDraws > 0 {
+			statistics.Occupancy[k] = float64(statistics.Counts[k]) / float64(statistics.NumDraws)
+		}
+		statistics.WeightError[k] = statistics.Occupancy[k] - w
+		statistics.TotalVariation += 0.5 * math.Abs(statistics.WeightError[k])
+	}
+	if statistics.numDwells > 0 {
+		statistics.MeanDwellTime = float64(statistics.NumDraws) / float64(statistics.numDwells)
+	}
+	return statistics
+}
+
+// ModeOccupancyFromCSV computes mode statistics from a file written by ToCSV
+func ModeOccupancyFromCSV(path string, mixture Mixture, numParticles int) (ModeOccupancy, error) {
+	samples, err := ReadCSV(path, mixture.Dim())
+	if err != nil {
+		return ModeOccupancy{}, err
+	}
+	tracker := NewModeTracker(mixture, numParticles)
+	for _, s := range samples {
+		if s.ID < 0 || s.ID >= numParticles {
+			return ModeOccupancy{}, fmt.Errorf("%s: particle %d out of range", path, s.ID)
+		}
+		tracker.Add(s)
+	}
+	return tracker.Occupancy(), nil
+}
+
+// ReadCSV reads the draws of a file written by ToCSV with their generated quantities
+func ReadCSV(path string, dim int) ([]bmc.Sample, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	rd := csv.NewReader(file)
+	rd.FieldsPerRecord = -1
+	records, err := rd.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	// files written before ToCSV had a header start with the first draw
+	first := 1
+	if len(records) != 0 && len(records[0]) != 0 && records[0][0] == "id" {
+		records = records[1:]
+		first = 2
+	}
+	samples := make([]bmc.Sample, len(records))
+	for i, record := range records {
+		if len(record) < 5+dim {
+			return nil, fmt.Errorf("%s: line %d has %d columns, want at least %d", path, i+first, len(record), 5+dim)
+		}
+		id, err := strconv.Atoi(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %v", path, i+first, err)
+		}
+		x := make([]float64, dim)
+		for j := range x {
+			if x[j], err = strconv.ParseFloat(record[5+j], 64); err != nil {
+				return nil, fmt.Errorf("%s: line %d: %v", path, i+first, err)
+			}
+		}
+		samples[i] = bmc.Sample{ID: id, X: x}
+		// generated quantities follow the per-draw columns and are empty during warmup
+		if column := 17 + 2*dim; len(record) > column && record[column] != "" {
+			generated := make([]float64, len(record)-column)
+			for j := range generated {
+				if generated[j], err = strconv.ParseFloat(record[column+j], 64); err != nil {
+					return nil, fmt.Errorf("%s: line %d: %v", path, i+first, err)
+				}
+			}
+			samples[i].Generated = generated
+		}
+	}
+	return samples, nil
+}
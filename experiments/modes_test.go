@@ -0,0 +1,100 @@
+package experiments
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+	ad "github.com/pbenner/autodiff"
+)
+
+func TestModeTracker(t *testing.T) {
+	mixture := newMixture([]float64{1, 3}, [][]float64{{-10}, {10}}, [][]float64{{1}, {1}})
+	tracker := NewModeTracker(mixture, 2)
+	// particle 0 dwells in modes 0, 1, 0 for 2, 3 and 1 draws, particle 1 stays in mode 1
+	modes := [][]int{{0, 0, 1, 1, 1, 0}, {1, 1, 1, 1}}
+	for k := 0; k != 6; k++ {
+		for id, particle := range modes {
+			if k < len(particle) {
+				tracker.Add(bmc.Sample{ID: id, X: []float64{mixture.Means[particle[k]][0] + 0.5}})
+			}
+		}
+	}
+	occupancy := tracker.Occupancy()
+	for _, c := range []struct {
+		name        string
+		statistics  ModeStatistics
+		counts      []int
+		numSwitches int
+		dwellTime   float64
+		tv          float64
+	}{
+		// occupancy 1/2, 1/2 against weights 1/4, 3/4
+		{"particle 0", occupancy.Particles[0], []int{3, 3}, 2, 6. / 3, 0.25},
+		{"particle 1", occupancy.Particles[1], []int{0, 4}, 0, 4, 0.25},
+		// occupancy 3/10, 7/10, and dwells are not merged across particles
+		{"overall", occupancy.Overall, []int{3, 7}, 2, 10. / 4, 0.05},
+	} {
+		s := c.statistics
+		if !reflect.DeepEqual(s.Counts, c.counts) || s.NumDraws != c.counts[0]+c.counts[1] {
+			t.Errorf("%s: %d draws with counts %v, want %v", c.name, s.NumDraws, s.Counts, c.counts)
+		}
+		if s.NumSwitches != c.numSwitches {
+			t.Errorf("%s: %d switches, want %d", c.name, s.NumSwitches, c.numSwitches)
+		}
+		if math.Abs(s.MeanDwellTime-c.dwellTime) > 1e-12 {
+			t.Errorf("%s: mean dwell time %v, want %v", c.name, s.MeanDwellTime, c.dwellTime)
+		}
+		if math.Abs(s.TotalVariation-c.tv) > 1e-12 {
+			t.Errorf("%s: total variation %v, want %v", c.name, s.TotalVariation, c.tv)
+		}
+	}
+	// the occupancy is a snapshot, adding draws does not change it
+	tracker.Add(bmc.Sample{ID: 1, X: []float64{-10}})
+	if occupancy.Particles[1].Counts[0] != 0 {
+		t.Errorf("occupancy changed by a later draw: %v", occupancy.Particles[1].Counts)
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bmc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BMC := bmc.BrownianMonteCarlo{
+		Masses:         []ad.Scalar{ad.NewReal(1), ad.NewReal(2)},
+		NumCollisions:  []int{3, 4},
+		NumAccepted:    []int{5, 6},
+		NumRejected:    []int{1, 0},
+		NumDivergences: []int{0, 1},
+		Names:          []string{"mu", "sigma"},
+		GeneratedNames: []string{"y1", "y2", "y3"},
+	}
+	samples := []bmc.Sample{
+		{ID: 0, Iteration: 0, X: []float64{0.5, 1.25}, Warmup: true},
+		{ID: 1, Iteration: 0, X: []float64{-3, 2}, Warmup: true, Divergent: true, DivergenceX: []float64{-30, 20}},
+		{ID: 0, Iteration: 1, X: []float64{0.75, 1}, Generated: []float64{1, -2.5, 1e-3}, Collided: true},
+		{ID: 1, Iteration: 1, X: []float64{-2, 1.5}, Generated: []float64{0, 4, 7}},
+	}
+	path := filepath.Join(dir, "draws.csv")
+	if err := ToCSV(path, samples, BMC); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadCSV(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(samples) {
+		t.Fatalf("%d draws read, want %d", len(read), len(samples))
+	}
+	for i, s := range samples {
+		if read[i].ID != s.ID || !reflect.DeepEqual(read[i].X, s.X) || !reflect.DeepEqual(read[i].Generated, s.Generated) {
+			t.Errorf("draw %d: read %+v, want id %d, x %v, generated %v", i, read[i], s.ID, s.X, s.Generated)
+		}
+	}
+}
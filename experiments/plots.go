@@ -34,15 +34,7 @@ func getFunctionName(i interface{}) string {
 
 func getImageName(path string, sampler bmc.MCMC, function interface{}, numParticles int, numSamples int, radius float64, collision string) string {
 	base := strings.Join([]string{path, getFunctionName(function)}, "")
-	var samplerName string
-	switch sampler.(type) {
-	case bmc.HMC:
-		samplerName = "HMC"
-	case bmc.NUTS:
-		samplerName = "NUTS"
-	default:
-		samplerName = "UndefinedSampler"
-	}
+	samplerName := getSamplerName(sampler)
 	particles := strconv.Itoa(numParticles)
 	samples := strconv.Itoa(numSamples)
 	Radius := strconv.FormatFloat(radius, 'f', -1, 64)
@@ -68,6 +60,11 @@ func PlotScatters(
 		panic(err)
 	}
 	p.Add(plotter.NewGrid())
+	p.Title.Text = strings.Join([]string{getFunctionName(targetDistribution), getSamplerName(BMC.Sampler), collision}, " ")
+	path := getImageName("multi-hmc-results/", BMC.Sampler, targetDistribution, numParticles, numSamples, radius, collision)
+	if radius != 0 {
+		path = getImageName("bmc-results/", BMC.Sampler, targetDistribution, numParticles, numSamples, radius, collision)
+	}
 	for i := 0; i != numParticles; i++ {
 		numAccepted := float64(numAccepted[i])
 		numRejected := float64(numRejected[i])
@@ -80,11 +77,6 @@ func PlotScatters(
 				data[j].Y = x.X[1]
 			}
 		}
-		if radius != 0 {
-			p.Title.Text = getImageName("bmc-results/", BMC.Sampler, targetDistribution, numParticles, numSamples, radius, collision)
-		} else {
-			p.Title.Text = getImageName("multi-hmc-results/", BMC.Sampler, targetDistribution, numParticles, numSamples, radius, collision)
-		}
 		s, err := plotter.NewScatter(data)
 		if err != nil {
 			panic(err)
@@ -94,7 +86,7 @@ func PlotScatters(
 		s.GlyphStyle.Color = pointColor(i, numParticles)
 		p.Add(s)
 	}
-	if err := p.Save(4*vg.Inch, 4*vg.Inch, p.Title.Text); err != nil {
+	if err := p.Save(4*vg.Inch, 4*vg.Inch, path); err != nil {
 		panic(err)
 	}
 }
@@ -0,0 +1,199 @@
+package experiments
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Weighting decides how much every particle counts in a pooled estimate.
+// Particles of different masses mix at different rates, so equal weights let
+// a slowly mixing particle skew the pooled estimate.
+type Weighting int
+
+const (
+	// ESSWeights weights particles by their effective sample size
+	ESSWeights Weighting = iota
+	// InverseVarianceWeights weights particles by their inverse squared Monte Carlo standard error
+	InverseVarianceWeights
+	// EqualWeights averages the particles
+	EqualWeights
+)
+
+// GetWeighting gets name of weighting, ess, mcse or equal, and returns the weighting
+func GetWeighting(name string) (Weighting, error) {
+	switch name {
+	case "ess":
+		return ESSWeights, nil
+	case "mcse":
+		return InverseVarianceWeights, nil
+	case "equal":
+		return EqualWeights, nil
+	default:
+		return 0, fmt.Errorf("unknown weighting %q", name)
+	}
+}
+
+func (weighting Weighting) String() string {
+	switch weighting {
+	case ESSWeights:
+		return "ESS"
+	case InverseVarianceWeights:
+		return "inverse MCSE^2"
+	default:
+		return "equal"
+	}
+}
+
+// Estimate is a Monte Carlo estimate of an expectation
+type Estimate struct {
+	Mean float64
+	// MCSE is the Monte Carlo standard error of Mean, NaN for fewer than 4 draws
+	MCSE float64
+	ESS  float64
+}
+
+// EstimateOf estimates the expectation of moment from the draws of one particle
+func EstimateOf(draws [][]float64, moment func(x []float64) float64) Estimate {
+	values := make([][]float64, len(draws))
+	pooled := make([]float64, len(draws))
+	for k, x := range draws {
+		pooled[k] = moment(x)
+		values[k] = []float64{pooled[k]}
+	}
+	mean, variance := meanAndVariance(pooled)
+	ess := math.NaN()
+	if e := bmc.EffectiveSampleSize([][][]float64{values}); len(e) == 1 {
+		ess = e[0]
+	}
+	return Estimate{Mean: mean, MCSE: math.Sqrt(variance / ess), ESS: ess}
+}
+
+// PooledEstimate is an expectation estimated by every particle and pooled over particles
+type PooledEstimate struct {
+	Particles []Estimate
+	// Weights of the particles in Pooled, they sum to 1
+	Weights []float64
+	// Pooled is the weighted mean of the particles. Its MCSE treats the particles as independent
+	// and its ESS is the total over particles.
+	Pooled Estimate
+	// Heterogeneity is Cochran's Q of the particles, the sum of their squared deviations from the
+	// inverse variance weighted mean in units of their MCSE. It follows a chi-square distribution
+	// with one degree of freedom less than the particles if they all estimate the same expectation.
+	Heterogeneity float64
+	PValue        float64
+}
+
+// Pool estimates the expectation of moment from the draws of every particle, indexed by
+// [particle][draw][dim], and pools the particles with weighting. Particles without a finite
+// weight, such as those with too few draws, get weight 0, and equal weights are used if none
+// has a positive weight.
+func Pool(draws [][][]float64, moment func(x []float64) float64, weighting Weighting) PooledEstimate {
+	pooled := PooledEstimate{
+		Particles: make([]Estimate, len(draws)),
+		Weights:   make([]float64, len(draws)),
+	}
+	for i, chain := range draws {
+		pooled.Particles[i] = EstimateOf(chain, moment)
+	}
+	total := 0.
+	for i, e := range pooled.Particles {
+		var w float64
+		switch weighting {
+		case ESSWeights:
+			w = e.ESS
+		case InverseVarianceWeights:
+			w = 1 / (e.MCSE * e.MCSE)
+		default:
+			w = 1
+		}
+		if math.IsNaN(w) || math.IsInf(w, 0) || w < 0 {
+			w = 0
+		}
+		pooled.Weights[i] = w
+		total += w
+	}
+	for i := range pooled.Weights {
+		if total > 0 {
+			pooled.Weights[i] /= total
+		} else {
+			pooled.Weights[i] = 1 / float64(len(draws))
+		}
+	}
+
+	variance := 0.
+	for i, e := range pooled.Particles {
+		w := pooled.Weights[i]
+		if w == 0 {
+			continue
+		}
+		pooled.Pooled.Mean += w * e.Mean
+		variance += w * w * e.MCSE * e.MCSE
+		pooled.Pooled.ESS += e.ESS
+	}
+	pooled.Pooled.MCSE = math.Sqrt(variance)
+	pooled.Heterogeneity, pooled.PValue = heterogeneity(pooled.Particles)
+	return pooled
+}
+
+// heterogeneity returns Cochran's Q of the estimates with a finite positive MCSE and its p-value
+func heterogeneity(estimates []Estimate) (q, pValue float64) {
+	mean, total, n := 0., 0., 0
+	for _, e := range estimates {
+		if w := 1 / (e.MCSE * e.MCSE); !math.IsNaN(w) && !math.IsInf(w, 0) {
+			mean += w * e.Mean
+			total += w
+			n++
+		}
+	}
+	if n < 2 {
+		return math.NaN(), math.NaN()
+	}
+	mean /= total
+	for _, e := range estimates {
+		if w := 1 / (e.MCSE * e.MCSE); !math.IsNaN(w) && !math.IsInf(w, 0) {
+			q += w * (e.Mean - mean) * (e.Mean - mean)
+		}
+	}
+	return q, distuv.ChiSquared{K: float64(n - 1)}.Survival(q)
+}
+
+// Moment is an expectation of the draws of a run with its name
+type Moment struct {
+	Name   string
+	Moment func(x []float64) float64
+	// Truth is the exact expectation, NaN if it is unknown
+	Truth float64
+}
+
+// Moments returns the first moments of the selected dimensions of a run and the cross
+// moment of the first two, with their exact values for mixture targets
+func (run Run) Moments() []Moment {
+	mixture, isMixture := GetMixture(run.Manifest.Target)
+	isMixture = isMixture && mixture.Dim() == run.Manifest.Dim
+	dims := run.Selected()
+	moments := make([]Moment, 0, len(dims)+1)
+	for _, d := range dims {
+		d := d
+		m := Moment{Name: fmt.Sprintf("E[%s]", run.label(d)), Moment: func(x []float64) float64 { return x[d] }, Truth: math.NaN()}
+		if isMixture {
+			m.Truth = mixture.Mean()[d]
+		}
+		moments = append(moments, m)
+	}
+	if len(dims) >= 2 {
+		i, j := dims[0], dims[1]
+		m := Moment{
+			Name:   fmt.Sprintf("E[%s %s]", run.label(i), run.label(j)),
+			Moment: func(x []float64) float64 { return x[i] * x[j] },
+			Truth:  math.NaN(),
+		}
+		if isMixture {
+			m.Truth = mixture.CrossMoment(i, j)
+		}
+		moments = append(moments, m)
+	}
+	return moments
+}
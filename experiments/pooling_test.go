@@ -0,0 +1,86 @@
+package experiments
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func firstDim(x []float64) float64 { return x[0] }
+
+// normalDraws returns chains of independent draws of N(mean, scale^2)
+func normalDraws(means, scales []float64, numDraws int, rng *rand.Rand) [][][]float64 {
+	draws := make([][][]float64, len(means))
+	for i := range draws {
+		for k := 0; k != numDraws; k++ {
+			draws[i] = append(draws[i], []float64{means[i] + scales[i]*rng.NormFloat64()})
+		}
+	}
+	return draws
+}
+
+func TestPoolEqualESS(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	base := normalDraws([]float64{0}, []float64{1}, 500, rng)[0]
+	// shifted copies of one chain have the same ESS
+	draws := make([][][]float64, 3)
+	for i := range draws {
+		for _, x := range base {
+			draws[i] = append(draws[i], []float64{x[0] + 0.01*float64(i)})
+		}
+	}
+	for i, w := range Pool(draws, firstDim, ESSWeights).Weights {
+		if math.Abs(w-1./3) > 1e-9 {
+			t.Errorf("particle %d: weight %v, want 1/3", i, w)
+		}
+	}
+}
+
+func TestPoolInverseVariance(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	pooled := Pool(normalDraws([]float64{0, 0, 0}, []float64{0.5, 1, 2}, 1000, rng), firstDim, InverseVarianceWeights)
+	mean, precision := 0., 0.
+	for _, e := range pooled.Particles {
+		mean += e.Mean / (e.MCSE * e.MCSE)
+		precision += 1 / (e.MCSE * e.MCSE)
+	}
+	mean /= precision
+	if math.Abs(pooled.Pooled.Mean-mean) > 1e-12 {
+		t.Errorf("pooled mean %v, want %v", pooled.Pooled.Mean, mean)
+	}
+	if mcse := 1 / math.Sqrt(precision); math.Abs(pooled.Pooled.MCSE-mcse) > 1e-12 {
+		t.Errorf("pooled MCSE %v, want %v", pooled.Pooled.MCSE, mcse)
+	}
+}
+
+func TestPoolTooFewDraws(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	draws := normalDraws([]float64{0, 0, 0}, []float64{1, 1, 1}, 100, rng)
+	draws[1] = draws[1][:3]
+	for _, weighting := range []Weighting{ESSWeights, InverseVarianceWeights} {
+		weights := Pool(draws, firstDim, weighting).Weights
+		if weights[1] != 0 {
+			t.Errorf("%s: weight %v of a particle with 3 draws", weighting, weights[1])
+		}
+		if total := weights[0] + weights[1] + weights[2]; math.Abs(total-1) > 1e-12 {
+			t.Errorf("%s: weights sum to %v", weighting, total)
+		}
+	}
+}
+
+func TestHeterogeneity(t *testing.T) {
+	q, pValue := heterogeneity([]Estimate{{Mean: 0, MCSE: 1}, {Mean: 2, MCSE: 1}, {Mean: 5, MCSE: math.NaN()}})
+	// deviations of 1 from the mean 1 of the finite estimates, P(chi^2_1 > 2)
+	if math.Abs(q-2) > 1e-12 || math.Abs(pValue-0.157299) > 1e-6 {
+		t.Errorf("Q %v with p-value %v, want 2 with 0.157299", q, pValue)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	scales := []float64{1, 1, 1, 1}
+	if pooled := Pool(normalDraws([]float64{0, 0, 0, 0}, scales, 1000, rng), firstDim, ESSWeights); pooled.PValue < 0.01 {
+		t.Errorf("Q %v with p-value %v of particles of the same target", pooled.Heterogeneity, pooled.PValue)
+	}
+	if pooled := Pool(normalDraws([]float64{0, 0, 0, 1}, scales, 1000, rng), firstDim, ESSWeights); pooled.PValue > 1e-6 {
+		t.Errorf("Q %v with p-value %v of a shifted particle", pooled.Heterogeneity, pooled.PValue)
+	}
+}
@@ -3,6 +3,7 @@ package experiments
 import (
 	"bufio"
 	"encoding/csv"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
@@ -10,17 +11,30 @@ import (
 	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
 )
 
-// GetNameFromBMC composes a filename to save
-func GetNameFromBMC(BMC bmc.BrownianMonteCarlo, collsion string, target string, numSamples int) string {
-	var samplerName string
-	switch BMC.Sampler.(type) {
+func getSamplerName(sampler bmc.MCMC) string {
+	switch sampler.(type) {
 	case bmc.HMC:
-		samplerName = "HMC"
+		return "HMC"
 	case bmc.NUTS:
-		samplerName = "NUTS"
+		return "NUTS"
+	case bmc.MALA:
+		return "MALA"
+	case bmc.RWM:
+		return "RWM"
+	case bmc.Slice:
+		return "Slice"
+	case bmc.RMHMC:
+		return "RMHMC"
+	case bmc.SGHMC:
+		return "SGHMC"
 	default:
-		samplerName = "UndefinedSampler"
+		return "UndefinedSampler"
 	}
+}
+
+// GetNameFromBMC composes a filename to save
+func GetNameFromBMC(BMC bmc.BrownianMonteCarlo, collsion string, target string, numSamples int) string {
+	samplerName := getSamplerName(BMC.Sampler)
 	collsionName := collsion
 	valueofParticles := strconv.Itoa(BMC.NumParticles)
 	valueofRadius := strconv.FormatFloat(BMC.InitialRadius, 'f', -1, 64)
@@ -33,13 +47,31 @@ func GetNameFromBMC(BMC bmc.BrownianMonteCarlo, collsion string, target string,
 	return filename
 }
 
-// ToCSV creates a file storing sample data
+// ToCSV creates a file storing sample data. A header names the columns, after it each row holds
+// id, mass, collisions, accepted, rejected, x..., divergences, divergent, divergence x...,
+// iteration, warmup, potential energy, kinetic energy, accepted, acceptance, step size,
+// tree depth, collided, radius, generated quantities...
+// where the divergence position is empty unless the draw is divergent, and the generated
+// quantities are empty during warmup. The counts in the first columns are totals of the
+// particle, the columns after the divergence position describe the draw. The columns of x
+// are named by BMC.Names, or X1, X2, ... without names, and the generated quantities by
+// BMC.GeneratedNames, or G1, G2, ...
+// Rows follow the order of the sample stream, draw k of particle i is row k*N+i.
 func ToCSV(path string, samples []bmc.Sample, BMC bmc.BrownianMonteCarlo) error {
 	file, err := os.Create(path)
 	if err != nil {
 		return err
 	}
-	wr := csv.NewWriter(bufio.NewWriter(file))
+	defer file.Close()
+	buffer := bufio.NewWriter(file)
+	wr := csv.NewWriter(buffer)
+	numGenerated := NumGenerated(samples)
+	if len(samples) != 0 {
+		header := csvHeader(Labels(BMC.Names, len(samples[0].X)), labelsOf(BMC.GeneratedNames, numGenerated, "G"))
+		if err := wr.Write(header); err != nil {
+			return err
+		}
+	}
 	for _, s := range samples {
 		id := strconv.Itoa(s.ID)
 		mass := strconv.FormatFloat(BMC.Masses[s.ID].GetValue(), 'f', -1, 64)
@@ -50,9 +82,127 @@ func ToCSV(path string, samples []bmc.Sample, BMC bmc.BrownianMonteCarlo) error
 		for _, v := range s.X {
 			vector = append(vector, strconv.FormatFloat(v, 'f', -1, 64))
 		}
+		divergences := strconv.Itoa(BMC.NumDivergences[s.ID])
+		divergent := flag(s.Divergent)
+		divergenceX := make([]string, len(s.X))
+		if s.Divergent {
+			for i, v := range s.DivergenceX {
+				divergenceX[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
 		line := append([]string{id, mass, collision, accepted, rejected}, vector...)
-		wr.Write(line)
+		line = append(line, divergences, divergent)
+		line = append(line, divergenceX...)
+		line = append(line,
+			strconv.Itoa(s.Iteration),
+			flag(s.Warmup),
+			strconv.FormatFloat(s.PotentialEnergy, 'f', -1, 64),
+			strconv.FormatFloat(s.KineticEnergy, 'f', -1, 64),
+			flag(s.Accepted),
+			strconv.FormatFloat(s.Acceptance, 'f', -1, 64),
+			strconv.FormatFloat(s.StepSize, 'f', -1, 64),
+			strconv.Itoa(s.TreeDepth),
+			flag(s.Collided),
+			strconv.FormatFloat(s.Radius, 'f', -1, 64),
+		)
+		generated := make([]string, numGenerated)
+		for i, v := range s.Generated {
+			generated[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		line = append(line, generated...)
+		if err := wr.Write(line); err != nil {
+			return err
+		}
 	}
 	wr.Flush()
-	return nil
+	if err := wr.Error(); err != nil {
+		return err
+	}
+	if err := buffer.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// NumGenerated returns the number of generated quantities of the draws, 0 if there are none
+func NumGenerated(samples []bmc.Sample) int {
+	for _, s := range samples {
+		if s.Generated != nil {
+			return len(s.Generated)
+		}
+	}
+	return 0
+}
+
+// csvHeader names the columns written by ToCSV
+func csvHeader(labels, generatedLabels []string) []string {
+	header := []string{"id", "mass", "numCollisions", "numAccepted", "numRejected"}
+	header = append(header, labels...)
+	header = append(header, "numDivergences", "divergent")
+	for _, label := range labels {
+		header = append(header, "divergence "+label)
+	}
+	header = append(header, "iteration", "warmup", "potentialEnergy", "kineticEnergy",
+		"accepted", "acceptance", "stepSize", "treeDepth", "collided", "radius")
+	return append(header, generatedLabels...)
+}
+
+// Labels returns names if there are dim of them, and X1, X2, ... otherwise
+func Labels(names []string, dim int) []string {
+	return labelsOf(names, dim, "X")
+}
+
+// labelsOf returns names if there are n of them, and prefix1, prefix2, ... otherwise
+func labelsOf(names []string, n int, prefix string) []string {
+	if len(names) == n {
+		return names
+	}
+	labels := make([]string, n)
+	for d := range labels {
+		labels[d] = fmt.Sprintf("%s%d", prefix, d+1)
+	}
+	return labels
+}
+
+// flag formats a boolean column as 0 or 1
+func flag(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// EnergiesToCSV creates a file storing the energies logged by BMC. Each row holds
+// id, iteration, start, before collision, after collision, collided.
+func EnergiesToCSV(path string, BMC bmc.BrownianMonteCarlo) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	buffer := bufio.NewWriter(file)
+	wr := csv.NewWriter(buffer)
+	for id, energies := range BMC.Energies {
+		for iteration, energy := range energies {
+			line := []string{
+				strconv.Itoa(id),
+				strconv.Itoa(iteration),
+				strconv.FormatFloat(energy.Start, 'f', -1, 64),
+				strconv.FormatFloat(energy.BeforeCollision, 'f', -1, 64),
+				strconv.FormatFloat(energy.AfterCollision, 'f', -1, 64),
+				flag(energy.Collided),
+			}
+			if err := wr.Write(line); err != nil {
+				return err
+			}
+		}
+	}
+	wr.Flush()
+	if err := wr.Error(); err != nil {
+		return err
+	}
+	if err := buffer.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
 }
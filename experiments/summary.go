@@ -0,0 +1,120 @@
+package experiments
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+)
+
+// Summary condenses the diagnostics of a run into numbers that can be compared across runs
+type Summary struct {
+	Name         string
+	Sampler      string
+	Collision    string
+	Target       string
+	NumParticles int
+	Radius       float64
+	NumDraws     int
+
+	// RHat and ESS per dimension, pooled over particles
+	RHat []float64
+	ESS  []float64
+	// GeneratedRHat and GeneratedESS per generated quantity, nil without them
+	GeneratedRHat []float64
+	GeneratedESS  []float64
+	// KL is the k-nearest-neighbor KL divergence of the pooled draws, NaN if the target has no known density
+	KL float64
+	// ModeTV is the total variation between mode occupancy and mode weights, NaN if the target is no mixture
+	ModeTV float64
+
+	MeanAcceptanceRate float64
+	NumDivergences     int
+	MeanEBFMI          float64
+	EvaluationsPerDraw float64
+	Elapsed            float64
+}
+
+// MaxRHat returns the largest R-hat over dimensions and generated quantities
+func (summary Summary) MaxRHat() float64 {
+	max := math.NaN()
+	for _, r := range append(append([]float64{}, summary.RHat...), summary.GeneratedRHat...) {
+		if math.IsNaN(max) || r > max {
+			max = r
+		}
+	}
+	return max
+}
+
+// MinESS returns the smallest effective sample size over dimensions and generated quantities
+func (summary Summary) MinESS() float64 {
+	min := math.NaN()
+	for _, ess := range append(append([]float64{}, summary.ESS...), summary.GeneratedESS...) {
+		if math.IsNaN(min) || ess < min {
+			min = ess
+		}
+	}
+	return min
+}
+
+// Summarize computes the summary of a run. The KL divergence uses at most maxKL pooled draws.
+func Summarize(run Run, maxKL int) Summary {
+	manifest := run.Manifest
+	summary := Summary{
+		Name:               strings.TrimSuffix(filepath.Base(manifest.Samples), ".csv"),
+		Sampler:            manifest.Sampler,
+		Collision:          manifest.Collision,
+		Target:             manifest.Target,
+		NumParticles:       manifest.NumParticles,
+		Radius:             manifest.Radius,
+		RHat:               bmc.RHat(run.Draws),
+		ESS:                bmc.EffectiveSampleSize(run.Draws),
+		KL:                 math.NaN(),
+		ModeTV:             math.NaN(),
+		MeanAcceptanceRate: meanOrNaN(manifest.AcceptanceRate),
+		EvaluationsPerDraw: manifest.EvaluationsPerDraw,
+		Elapsed:            manifest.Elapsed,
+	}
+	if run.Generated != nil {
+		summary.GeneratedRHat = bmc.RHat(run.Generated)
+		summary.GeneratedESS = bmc.EffectiveSampleSize(run.Generated)
+	}
+	pooled := make([][]float64, 0)
+	for _, draws := range run.Draws {
+		summary.NumDraws += len(draws)
+		pooled = append(pooled, draws...)
+	}
+	for _, n := range manifest.NumDivergences {
+		summary.NumDivergences += n
+	}
+	ebfmi := make([]float64, 0, len(manifest.EBFMI))
+	for _, e := range manifest.EBFMI {
+		if e != nil {
+			ebfmi = append(ebfmi, *e)
+		}
+	}
+	summary.MeanEBFMI = meanOrNaN(ebfmi)
+	if mixture, ok := GetMixture(manifest.Target); ok && mixture.Dim() == manifest.Dim {
+		summary.KL = KLDivergenceKNN(thinDraws(pooled, maxKL), mixture.LogDensity, 5)
+		tracker := NewModeTracker(mixture, len(run.Draws))
+		for id, draws := range run.Draws {
+			for _, x := range draws {
+				tracker.Add(bmc.Sample{ID: id, X: x})
+			}
+		}
+		summary.ModeTV = tracker.Occupancy().Overall.TotalVariation
+	}
+	return summary
+}
+
+func meanOrNaN(xs []float64) float64 {
+	if len(xs) == 0 {
+		return math.NaN()
+	}
+	mean := 0.
+	for _, x := range xs {
+		mean += x / float64(len(xs))
+	}
+	return mean
+}
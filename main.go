@@ -1,115 +1,60 @@
 package main
 
 import (
-	"flag"
 	"fmt"
-	"runtime"
+	"os"
 	"strings"
-	"time"
-
-	"github.com/kim-hyunsu/BrownianMonteCarlo/experiments"
-
-	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
-	ad "github.com/pbenner/autodiff"
 )
 
-func main() {
-	numParticles := flag.Int("numParticles", runtime.NumCPU(), "Number of particles.")
-	numSamples := flag.Int("numSamples", 1000, "Number of samples per particle.")
-	numSteps := flag.Int("numSteps", 10, "Number of steps (L).")
-	stepSize := flag.Float64("stepSize", 0., "Size of a step (epsilon)")
-	collision := flag.String("collision", "NormalCollision", "Type of collision.")
-	mcmc := flag.String("mcmc", "nuts", "HMC or NUTS.")
-	radius := flag.Float64("radius", 1.0, "Radius of each particle.")
-	mass := flag.Float64("mass", 1.0, "Masses of each particle")
-	dist := flag.String("dist", "", "Target probability distribution.")
-	dim := flag.Int("dim", 2, "Dimension of target distribution.")
-	verbose := flag.Bool("verbose", false, "List all samples")
-
-	flag.Parse()
+// command is a subcommand of the CLI
+type command struct {
+	name, description string
+	run               func(args []string) error
+}
 
-	var sampler bmc.MCMC
-	var collide bmc.Collision
-	var maxAdapt int
+var commands = []command{
+	{"sample", "sample a target with BMC and save draws, energies and a manifest", sampleCommand},
+	{"diagnose", "print R-hat, ESS, KL and mode tables of a run", diagnoseCommand},
+	{"plot", "render the figures of a run", plotCommand},
+	{"compare", "put the diagnostics of several runs side by side", compareCommand},
+	{"evidence", "estimate the log normalizing constant of the target of a run", evidenceCommand},
+	{"sweep", "sample every combination of a parameter grid", sweepCommand},
+	{"worker", "serve transitions of particles to a distributed sample run", workerCommand},
+}
 
-	// Sampler
-	switch *mcmc {
-	case "NUTS":
-		sampler = bmc.NUTS{
-			StepSize: ad.NewScalar(ad.RealType, *stepSize),
-		}
-	case "HMC":
-		sampler = bmc.HMC{
-			StepSize: ad.NewScalar(ad.RealType, *stepSize),
-			NumSteps: *numSteps,
-		}
-	default:
-		panic("No Sampler.")
+func main() {
+	args := os.Args[1:]
+	// without a subcommand the flags are those of sample, as before subcommands existed
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" && args[0] != "--help" {
+		args = append([]string{"sample"}, args...)
 	}
-
-	// Collide
-	switch *collision {
-	case "NormalCollision":
-		collide = bmc.NormalCollision
-	default:
-		collide = bmc.NoCollision
+	for _, c := range commands {
+		if c.name == args[0] {
+			if err := c.run(args[1:]); err != nil {
+				fail(err)
+			}
+			return
+		}
 	}
-	masses := make([]ad.Scalar, *numParticles)
-	radii := make([]float64, *numParticles)
-	for i := 0; i != *numParticles; i++ {
-		// masses[i] = ad.NewScalar(ad.RealType, *mass)
-		masses[i] = ad.NewScalar(ad.RealType, (*mass)*float64(i+1))
-		radii[i] = *radius
+	usage()
+	if args[0] != "-h" && args[0] != "-help" && args[0] != "--help" && args[0] != "help" {
+		fail(fmt.Errorf("unknown command %q", args[0]))
 	}
-	sample := make(chan bmc.Sample)
-	collidedSample := make(chan bmc.Sample, *numSamples)
+}
 
-	// adaptive step size
-	if *stepSize == 0. {
-		maxAdapt = *numSamples / 100
-	} else {
-		maxAdapt = 0
-	}
-	BMC := bmc.BrownianMonteCarlo{
-		Sampler:      sampler,
-		Collide:      collide,
-		NumParticles: *numParticles,
-		Radius:       radii,
-		Masses:       masses,
-		MaxAdapt:     maxAdapt,
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: bmc <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", c.name, c.description)
 	}
-	target := experiments.GetDistribution(*dist)
-	initialX := make([]float64, *dim)
-	begin := time.Now()
-	BMC.Sample(target, ad.NewVector(ad.RealType, initialX), sample, collidedSample)
-	samples := make([]bmc.Sample, 0)
-	for i := 0; i != *numSamples; i++ {
-		s := <-sample
-		if *verbose {
-			fmt.Println(i, s)
-		}
-		samples = append(samples, s)
-	}
-	fmt.Println("[", time.Since(begin), "]")
-	BMC.Stop()
-	// collidedSamples := make([]bmc.Sample, 0)
-	// for s := range collidedSample {
-	// 	collidedSamples = append(collidedSamples, s)
-	// }
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run bmc <command> -help for the flags of a command.")
+}
 
-	// experiments.PlotScatters(
-	// 	BMC,
-	// 	samples,
-	// 	*numParticles, *numSamples,
-	// 	*radius,
-	// 	BMC.NumAccepted, BMC.NumRejected, BMC.NumCollisions,
-	// 	target,
-	// 	*collision,
-	// )
-	filename := experiments.GetNameFromBMC(BMC, *collision, *dist, len(samples))
-	path := strings.Join([]string{"csv/", filename, ".csv"}, "")
-	err := experiments.ToCSV(path, samples, BMC)
-	if err != nil {
-		panic(err)
-	}
+// fail reports an error and exits
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
 }
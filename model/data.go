@@ -0,0 +1,76 @@
+package model
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Data is a table of numeric columns read from a CSV file with a header
+type Data struct {
+	Names   []string
+	columns map[string][]float64
+}
+
+// ReadCSV reads a CSV file whose first row names the columns
+func ReadCSV(path string) (Data, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Data{}, err
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return Data{}, err
+	}
+	if len(records) == 0 {
+		return Data{}, fmt.Errorf("%s: no header", path)
+	}
+	data := Data{Names: records[0], columns: make(map[string][]float64, len(records[0]))}
+	for j, name := range data.Names {
+		column := make([]float64, len(records)-1)
+		for i, record := range records[1:] {
+			if column[i], err = strconv.ParseFloat(record[j], 64); err != nil {
+				return Data{}, fmt.Errorf("%s: line %d, column %s: %v", path, i+2, name, err)
+			}
+		}
+		data.columns[name] = column
+	}
+	return data, nil
+}
+
+// Len returns the number of rows
+func (data Data) Len() int {
+	if len(data.Names) == 0 {
+		return 0
+	}
+	return len(data.columns[data.Names[0]])
+}
+
+// Column returns the values of a column
+func (data Data) Column(name string) ([]float64, error) {
+	column, ok := data.columns[name]
+	if !ok {
+		return nil, fmt.Errorf("no column %q", name)
+	}
+	return column, nil
+}
+
+// Matrix returns the rows of the given columns, as the design matrix of Linear
+func (data Data) Matrix(names ...string) ([][]float64, error) {
+	X := make([][]float64, data.Len())
+	for i := range X {
+		X[i] = make([]float64, len(names))
+	}
+	for j, name := range names {
+		column, err := data.Column(name)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range column {
+			X[i][j] = v
+		}
+	}
+	return X, nil
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"math"
+
+	ad "github.com/pbenner/autodiff"
+	ads "github.com/pbenner/autodiff/simple"
+)
+
+// Predictor is the linear predictor of data point i
+type Predictor func(v Values, i int) ad.Scalar
+
+// Linear is the predictor intercept + X[i] . beta, a nil intercept is zero
+func Linear(X [][]float64, beta, intercept *Parameter) Predictor {
+	return func(v Values, i int) ad.Scalar {
+		eta := ad.NewScalar(ad.RealType, 0)
+		if intercept != nil {
+			eta = v.Scalar(intercept)
+		}
+		for j, b := range v.Get(beta) {
+			eta = ads.Add(eta, ads.Mul(ad.NewReal(X[i][j]), b))
+		}
+		return eta
+	}
+}
+
+// Gaussian adds the likelihood y[i] ~ Normal(mean(i), sigma), sigma is a Positive block of size 1
+func (m *Model) Gaussian(y []float64, mean Predictor, sigma *Parameter) {
+	m.Factor(func(v Values) ad.Scalar {
+		s := v.Scalar(sigma)
+		logLikelihood := ad.NewScalar(ad.RealType, 0)
+		for i, yi := range y {
+			logLikelihood = ads.Add(logLikelihood, normalLogDensity(ad.NewReal(yi), mean(v, i), s))
+		}
+		return logLikelihood
+	})
+}
+
+// BernoulliLogit adds the likelihood y[i] ~ Bernoulli(logistic(logit(i))) for y[i] in {0, 1}
+func (m *Model) BernoulliLogit(y []float64, logit Predictor) {
+	m.Factor(func(v Values) ad.Scalar {
+		logLikelihood := ad.NewScalar(ad.RealType, 0)
+		for i, yi := range y {
+			eta := logit(v, i)
+			logLikelihood = ads.Add(logLikelihood, ads.Sub(ads.Mul(ad.NewReal(yi), eta), softplus(eta)))
+		}
+		return logLikelihood
+	})
+}
+
+// PoissonLog adds the likelihood y[i] ~ Poisson(exp(logRate(i))) for counts y[i]
+func (m *Model) PoissonLog(y []float64, logRate Predictor) {
+	constant := 0.
+	for _, yi := range y {
+		lgamma, _ := math.Lgamma(yi + 1)
+		constant -= lgamma
+	}
+	m.Factor(func(v Values) ad.Scalar {
+		logLikelihood := ad.NewScalar(ad.RealType, constant)
+		for i, yi := range y {
+			eta := logRate(v, i)
+			logLikelihood = ads.Add(logLikelihood, ads.Sub(ads.Mul(ad.NewReal(yi), eta), ads.Exp(eta)))
+		}
+		return logLikelihood
+	})
+}
+
+// softplus is log(1 + exp(x)), computed without overflow
+func softplus(x ad.Scalar) ad.Scalar {
+	one := ad.NewReal(1)
+	if x.GetValue() > 0 {
+		return ads.Add(x, ads.Log(ads.Add(one, ads.Exp(ads.Neg(x)))))
+	}
+	return ads.Log(ads.Add(one, ads.Exp(x)))
+}
@@ -0,0 +1,258 @@
+// Package model composes posteriors for BMC from priors and likelihoods over named parameters.
+//
+// A model declares blocks of parameters with their support, adds priors, likelihoods
+// and custom factors, and compiles to a log density Target with a Transform:
+//
+//	m := model.New()
+//	beta := m.Real("beta", 3)
+//	sigma := m.Positive("sigma", 1)
+//	m.Prior(beta, model.Normal{Mu: 0, Sigma: 10})
+//	m.Prior(sigma, model.HalfCauchy{Scale: 1})
+//	m.Gaussian(y, model.Linear(X, beta, nil), sigma)
+//	m.PredictGaussian("y_rep", len(y), model.Linear(X, beta, nil), sigma)
+//	result, err := bmc.Run(ctx, m.Target(), m.Options(options))
+package model
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+	ad "github.com/pbenner/autodiff"
+	ads "github.com/pbenner/autodiff/simple"
+)
+
+// Parameter is a named block of parameters of a model
+type Parameter struct {
+	Name string
+	// Size is the number of constrained values of the block
+	Size int
+
+	offset    int
+	transform bmc.Transform
+	initial   []float64
+	labels    []string
+}
+
+// Model is a posterior built from log density terms over named parameters
+type Model struct {
+	parameters []*Parameter
+	terms      []func(v Values) ad.Scalar
+	dim        int
+	generated  []generated
+}
+
+// generated is a block of generated quantities
+type generated struct {
+	size     int
+	generate func(v Values, rng *rand.Rand) []float64
+	labels   []string
+}
+
+// Values are the constrained parameters of one evaluation of a model
+type Values struct {
+	scalars []ad.Scalar
+}
+
+// Get returns the values of a parameter block
+func (v Values) Get(p *Parameter) []ad.Scalar {
+	return v.scalars[p.offset : p.offset+p.Size]
+}
+
+// Scalar returns the first value of a parameter block, for blocks of size 1
+func (v Values) Scalar(p *Parameter) ad.Scalar {
+	return v.scalars[p.offset]
+}
+
+// New returns an empty model
+func New() *Model {
+	return &Model{}
+}
+
+// Real declares an unconstrained block of size values
+func (m *Model) Real(name string, size int) *Parameter {
+	return m.declare(name, size, bmc.IdentityTransform{Dim: size}, 0, vectorLabels(name, size))
+}
+
+// Positive declares a block of size positive values
+func (m *Model) Positive(name string, size int) *Parameter {
+	return m.declare(name, size, bmc.LogTransform{Dim: size}, 1, vectorLabels(name, size))
+}
+
+// UnitInterval declares a block of size values in (0, 1)
+func (m *Model) UnitInterval(name string, size int) *Parameter {
+	return m.declare(name, size, bmc.LogitTransform{Dim: size, Lower: 0, Upper: 1}, 0.5, vectorLabels(name, size))
+}
+
+// CholeskyCorr declares the Cholesky factor of a K x K correlation matrix,
+// flattened row by row into K*K values
+func (m *Model) CholeskyCorr(name string, K int) *Parameter {
+	labels := make([]string, 0, K*K)
+	for i := 1; i <= K; i++ {
+		for j := 1; j <= K; j++ {
+			labels = append(labels, fmt.Sprintf("%s[%d,%d]", name, i, j))
+		}
+	}
+	p := m.declare(name, K*K, bmc.CholeskyCorrTransform{K: K}, 0, labels)
+	for i := 0; i != K; i++ {
+		p.initial[i*K+i] = 1
+	}
+	return p
+}
+
+func (m *Model) declare(name string, size int, transform bmc.Transform, initial float64, labels []string) *Parameter {
+	p := &Parameter{
+		Name:      name,
+		Size:      size,
+		offset:    m.dim,
+		transform: transform,
+		initial:   make([]float64, size),
+		labels:    labels,
+	}
+	for i := range p.initial {
+		p.initial[i] = initial
+	}
+	m.parameters = append(m.parameters, p)
+	m.dim += size
+	return p
+}
+
+// vectorLabels names the values of a block, name for a single value and name[i] otherwise
+func vectorLabels(name string, size int) []string {
+	if size == 1 {
+		return []string{name}
+	}
+	labels := make([]string, size)
+	for i := range labels {
+		labels[i] = fmt.Sprintf("%s[%d]", name, i+1)
+	}
+	return labels
+}
+
+// Factor adds a custom term to the log density
+func (m *Model) Factor(logDensity func(v Values) ad.Scalar) {
+	m.terms = append(m.terms, logDensity)
+}
+
+// Prior adds a prior on a parameter block
+func (m *Model) Prior(p *Parameter, prior Prior) {
+	m.Factor(func(v Values) ad.Scalar {
+		return prior.LogDensity(v.Get(p))
+	})
+}
+
+// Generate adds a block of size quantities derived from every draw after warmup,
+// such as predictions or transformed parameters, labelled like a parameter block
+func (m *Model) Generate(name string, size int, generate func(v Values, rng *rand.Rand) []float64) {
+	m.generated = append(m.generated, generated{size: size, generate: generate, labels: vectorLabels(name, size)})
+}
+
+// Dim returns the number of constrained values of the model
+func (m *Model) Dim() int {
+	return m.dim
+}
+
+// Parameters returns the parameter blocks in the order of the draws
+func (m *Model) Parameters() []*Parameter {
+	return m.parameters
+}
+
+// Names labels every value of a draw, such as beta[1] or sigma
+func (m *Model) Names() []string {
+	names := make([]string, 0, m.dim)
+	for _, p := range m.parameters {
+		names = append(names, p.labels...)
+	}
+	return names
+}
+
+// GeneratedNames labels every generated quantity, in the order of Sample.Generated
+func (m *Model) GeneratedNames() []string {
+	names := make([]string, 0)
+	for _, g := range m.generated {
+		names = append(names, g.labels...)
+	}
+	return names
+}
+
+// GeneratedQuantities computes all generated blocks of a draw, nil if there are none.
+// Sampling checks their number against GeneratedNames.
+func (m *Model) GeneratedQuantities() bmc.GeneratedQuantities {
+	if len(m.generated) == 0 {
+		return nil
+	}
+	return func(x []float64, rng *rand.Rand) []float64 {
+		v := valuesOf(x)
+		quantities := make([]float64, 0)
+		for _, g := range m.generated {
+			quantities = append(quantities, g.generate(v, rng)...)
+		}
+		return quantities
+	}
+}
+
+// Extract splits a draw into its parameter blocks
+func (m *Model) Extract(x []float64) map[string][]float64 {
+	blocks := make(map[string][]float64, len(m.parameters))
+	for _, p := range m.parameters {
+		blocks[p.Name] = x[p.offset : p.offset+p.Size]
+	}
+	return blocks
+}
+
+// Target returns the log posterior density of the constrained parameters, up to a constant.
+// Sample it with LogDensity and the Transform of the model.
+func (m *Model) Target() bmc.Target {
+	return func(x ad.Vector) ad.Scalar {
+		v := Values{scalars: bmc.Components(x)}
+		logDensity := ad.NewScalar(ad.RealType, 0)
+		for _, term := range m.terms {
+			logDensity = ads.Add(logDensity, term(v))
+		}
+		return logDensity
+	}
+}
+
+// Transform maps the unconstrained space of the sampler to the supports of the parameters
+func (m *Model) Transform() bmc.Transform {
+	transforms := make(bmc.Transforms, len(m.parameters))
+	for i, p := range m.parameters {
+		transforms[i] = p.transform
+	}
+	return transforms
+}
+
+// InitialX returns a point inside the support of every parameter
+func (m *Model) InitialX() []float64 {
+	x := make([]float64, 0, m.dim)
+	for _, p := range m.parameters {
+		x = append(x, p.initial...)
+	}
+	return x
+}
+
+// Options completes options of bmc.Run with the dimension, names, transform and initial
+// point of the model, marks the target as a log density and adds its generated quantities
+func (m *Model) Options(options bmc.Options) bmc.Options {
+	options.Dim = m.dim
+	options.Names = m.Names()
+	if len(m.generated) != 0 {
+		options.GeneratedQuantities = m.GeneratedQuantities()
+		options.GeneratedNames = m.GeneratedNames()
+	}
+	options.Transform = m.Transform()
+	options.LogDensity = true
+	if options.InitialX == nil {
+		options.InitialX = m.InitialX()
+	}
+	return options
+}
+
+// valuesOf wraps a draw as constant values
+func valuesOf(x []float64) Values {
+	scalars := make([]ad.Scalar, len(x))
+	for i, xi := range x {
+		scalars[i] = ad.NewReal(xi)
+	}
+	return Values{scalars: scalars}
+}
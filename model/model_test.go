@@ -0,0 +1,97 @@
+package model
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+	ad "github.com/pbenner/autodiff"
+)
+
+func TestNames(t *testing.T) {
+	m := New()
+	m.Real("beta", 3)
+	m.Positive("sigma", 1)
+	m.CholeskyCorr("L", 2)
+	want := []string{"beta[1]", "beta[2]", "beta[3]", "sigma", "L[1,1]", "L[1,2]", "L[2,1]", "L[2,2]"}
+	if names := m.Names(); !reflect.DeepEqual(names, want) {
+		t.Errorf("names %v, want %v", names, want)
+	}
+	if m.Dim() != 8 || m.Transform().UnconstrainedDim() != 5 {
+		t.Errorf("dim %d, unconstrained dim %d", m.Dim(), m.Transform().UnconstrainedDim())
+	}
+	blocks := m.Extract([]float64{1, 2, 3, 4, 1, 0, 0, 1})
+	if !reflect.DeepEqual(blocks["sigma"], []float64{4}) || !reflect.DeepEqual(blocks["beta"], []float64{1, 2, 3}) {
+		t.Errorf("extracted %v", blocks)
+	}
+}
+
+func TestGaussianModel(t *testing.T) {
+	y := []float64{0.5, -1, 2}
+	X := [][]float64{{1}, {2}, {3}}
+	m := New()
+	beta := m.Real("beta", 1)
+	sigma := m.Positive("sigma", 1)
+	m.Prior(beta, Normal{Mu: 0, Sigma: 10})
+	m.Prior(sigma, Gamma{Shape: 2, Rate: 1})
+	m.Gaussian(y, Linear(X, beta, nil), sigma)
+
+	b, s := 0.3, 1.5
+	want := -0.5*(b/10)*(b/10) - math.Log(10) - 0.5*math.Log(2*math.Pi)
+	want += math.Log(s) - s // Gamma(2, 1)
+	for i := range y {
+		z := (y[i] - X[i][0]*b) / s
+		want += -0.5*z*z - math.Log(s) - 0.5*math.Log(2*math.Pi)
+	}
+	got := m.Target()(ad.NewVector(ad.RealType, []float64{b, s})).GetValue()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("log density %v, want %v", got, want)
+	}
+	options := m.Options(bmc.Options{})
+	if !options.LogDensity || options.Dim != 2 || !reflect.DeepEqual(options.InitialX, []float64{0, 1}) {
+		t.Errorf("options %+v", options)
+	}
+}
+
+// integrate approximates the integral of exp(prior) over (a, b) by the midpoint rule
+func integrate(prior Prior, a, b float64, n int) float64 {
+	h := (b - a) / float64(n)
+	total := 0.
+	for i := 0; i != n; i++ {
+		x := ad.NewReal(a + (float64(i)+0.5)*h)
+		total += math.Exp(prior.LogDensity([]ad.Scalar{x}).GetValue()) * h
+	}
+	return total
+}
+
+func TestPriorsNormalized(t *testing.T) {
+	for name, c := range map[string]struct {
+		prior Prior
+		a, b  float64
+		tol   float64
+	}{
+		"Normal":     {Normal{Mu: 1, Sigma: 2}, -20, 20, 1e-6},
+		"HalfCauchy": {HalfCauchy{Scale: 1}, 0, 1e4, 1e-3},
+		"Gamma":      {Gamma{Shape: 3, Rate: 2}, 0, 50, 1e-6},
+		"Beta":       {Beta{Alpha: 2, Beta: 3}, 0, 1, 1e-6},
+	} {
+		if total := integrate(c.prior, c.a, c.b, 100000); math.Abs(total-1) > c.tol {
+			t.Errorf("%s integrates to %v", name, total)
+		}
+	}
+}
+
+func TestLikelihoods(t *testing.T) {
+	m := New()
+	alpha := m.Real("alpha", 1)
+	eta := func(v Values, i int) ad.Scalar { return v.Scalar(alpha) }
+	m.BernoulliLogit([]float64{1, 0}, eta)
+	m.PoissonLog([]float64{3}, eta)
+	a := 0.7
+	p := 1 / (1 + math.Exp(-a))
+	want := math.Log(p) + math.Log(1-p) + 3*a - math.Exp(a) - math.Log(6)
+	if got := m.Target()(ad.NewVector(ad.RealType, []float64{a})).GetValue(); math.Abs(got-want) > 1e-9 {
+		t.Errorf("log likelihood %v, want %v", got, want)
+	}
+}
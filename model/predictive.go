@@ -0,0 +1,82 @@
+package model
+
+import (
+	"math"
+	"math/rand"
+)
+
+// PredictGaussian generates replicates y_rep[i] ~ Normal(mean(i), sigma) of n data points,
+// the posterior predictive of Gaussian
+func (m *Model) PredictGaussian(name string, n int, mean Predictor, sigma *Parameter) {
+	m.Generate(name, n, func(v Values, rng *rand.Rand) []float64 {
+		s := v.Scalar(sigma).GetValue()
+		y := make([]float64, n)
+		for i := range y {
+			y[i] = mean(v, i).GetValue() + s*rng.NormFloat64()
+		}
+		return y
+	})
+}
+
+// PredictBernoulliLogit generates replicates y_rep[i] ~ Bernoulli(logistic(logit(i))) of n data points,
+// the posterior predictive of BernoulliLogit
+func (m *Model) PredictBernoulliLogit(name string, n int, logit Predictor) {
+	m.Generate(name, n, func(v Values, rng *rand.Rand) []float64 {
+		y := make([]float64, n)
+		for i := range y {
+			if rng.Float64() < 1/(1+math.Exp(-logit(v, i).GetValue())) {
+				y[i] = 1
+			}
+		}
+		return y
+	})
+}
+
+// PredictPoissonLog generates replicates y_rep[i] ~ Poisson(exp(logRate(i))) of n data points,
+// the posterior predictive of PoissonLog
+func (m *Model) PredictPoissonLog(name string, n int, logRate Predictor) {
+	m.Generate(name, n, func(v Values, rng *rand.Rand) []float64 {
+		y := make([]float64, n)
+		for i := range y {
+			y[i] = samplePoisson(math.Exp(logRate(v, i).GetValue()), rng)
+		}
+		return y
+	})
+}
+
+// samplePoisson draws from a Poisson distribution, NaN if the rate is not finite or negative.
+// Below a rate of 10 it counts the arrivals of a unit rate Poisson process until time rate,
+// above it uses the transformed rejection with squeeze of Hormann (1993), in constant expected time.
+func samplePoisson(rate float64, rng *rand.Rand) float64 {
+	switch {
+	case !(rate >= 0) || math.IsInf(rate, 1):
+		return math.NaN()
+	case rate < 10:
+		count := 0.
+		for t := rng.ExpFloat64(); t < rate; t += rng.ExpFloat64() {
+			count++
+		}
+		return count
+	}
+	logRate := math.Log(rate)
+	b := 0.931 + 2.53*math.Sqrt(rate)
+	a := -0.059 + 0.02483*b
+	inverseAlpha := 1.1239 + 1.1328/(b-3.4)
+	vr := 0.9277 - 3.6224/(b-2)
+	for {
+		u := rng.Float64() - 0.5
+		v := rng.Float64()
+		us := 0.5 - math.Abs(u)
+		k := math.Floor((2*a/us+b)*u + rate + 0.43)
+		if us >= 0.07 && v <= vr {
+			return k
+		}
+		if k < 0 || us < 0.013 && v > us {
+			continue
+		}
+		logFactorial, _ := math.Lgamma(k + 1)
+		if math.Log(v*inverseAlpha/(a/(us*us)+b)) <= -rate+k*logRate-logFactorial {
+			return k
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestPredictive(t *testing.T) {
+	m := New()
+	beta := m.Real("beta", 1)
+	sigma := m.Positive("sigma", 1)
+	m.PredictGaussian("y_rep", 2, Linear([][]float64{{1}, {2}}, beta, nil), sigma)
+	m.PredictPoissonLog("count", 1, Linear([][]float64{{1}}, beta, nil))
+	want := []string{"y_rep[1]", "y_rep[2]", "count"}
+	if names := m.GeneratedNames(); !reflect.DeepEqual(names, want) {
+		t.Errorf("names %v, want %v", names, want)
+	}
+
+	generate := m.GeneratedQuantities()
+	rng := rand.New(rand.NewSource(1))
+	x := []float64{math.Log(3), 0.5}
+	n := 20000
+	mean := make([]float64, 3)
+	for k := 0; k != n; k++ {
+		for j, y := range generate(x, rng) {
+			mean[j] += y / float64(n)
+		}
+	}
+	for j, want := range []float64{math.Log(3), 2 * math.Log(3), 3} {
+		if math.Abs(mean[j]-want) > 0.05 {
+			t.Errorf("mean of %s %v, want %v", m.GeneratedNames()[j], mean[j], want)
+		}
+	}
+}
+
+func TestSamplePoisson(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	n := 20000
+	for _, rate := range []float64{0, 3, 10, 50, 1e6} {
+		mean, variance := 0., 0.
+		draws := make([]float64, n)
+		for k := range draws {
+			draws[k] = samplePoisson(rate, rng)
+			mean += draws[k] / float64(n)
+		}
+		for _, y := range draws {
+			variance += (y - mean) * (y - mean) / float64(n-1)
+		}
+		// the mean is within 5 standard errors, the variance within 5 percent
+		if math.Abs(mean-rate) > 5*math.Sqrt(rate/float64(n)) || math.Abs(variance-rate) > 0.05*rate {
+			t.Errorf("rate %v: mean %v and variance %v", rate, mean, variance)
+		}
+	}
+	for _, rate := range []float64{math.Inf(1), math.NaN(), -1} {
+		if y := samplePoisson(rate, rng); !math.IsNaN(y) {
+			t.Errorf("rate %v: draw %v, want NaN", rate, y)
+		}
+	}
+}
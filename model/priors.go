@@ -0,0 +1,108 @@
+package model
+
+import (
+	"math"
+
+	ad "github.com/pbenner/autodiff"
+	ads "github.com/pbenner/autodiff/simple"
+)
+
+// Prior is the log density of a block of parameters
+type Prior interface {
+	LogDensity(x []ad.Scalar) ad.Scalar
+}
+
+// Normal is a Gaussian prior on every value of a block
+type Normal struct {
+	Mu, Sigma float64
+}
+
+// LogDensity returns the log prior density
+func (d Normal) LogDensity(x []ad.Scalar) ad.Scalar {
+	return sum(x, func(xi ad.Scalar) ad.Scalar {
+		return normalLogDensity(xi, ad.NewReal(d.Mu), ad.NewReal(d.Sigma))
+	})
+}
+
+// HalfCauchy is a Cauchy prior folded to positive values, for Positive blocks
+type HalfCauchy struct {
+	Scale float64
+}
+
+// LogDensity returns the log prior density
+func (d HalfCauchy) LogDensity(x []ad.Scalar) ad.Scalar {
+	constant := math.Log(2 / (math.Pi * d.Scale))
+	return sum(x, func(xi ad.Scalar) ad.Scalar {
+		z := ads.Div(xi, ad.NewReal(d.Scale))
+		return ads.Sub(ad.NewReal(constant), ads.Log(ads.Add(ad.NewReal(1), ads.Mul(z, z))))
+	})
+}
+
+// Gamma is a gamma prior with shape and rate on every value of a Positive block
+type Gamma struct {
+	Shape, Rate float64
+}
+
+// LogDensity returns the log prior density
+func (d Gamma) LogDensity(x []ad.Scalar) ad.Scalar {
+	lgamma, _ := math.Lgamma(d.Shape)
+	constant := d.Shape*math.Log(d.Rate) - lgamma
+	return sum(x, func(xi ad.Scalar) ad.Scalar {
+		logDensity := ads.Mul(ad.NewReal(d.Shape-1), ads.Log(xi))
+		return ads.Add(ad.NewReal(constant), ads.Sub(logDensity, ads.Mul(ad.NewReal(d.Rate), xi)))
+	})
+}
+
+// Beta is a beta prior on every value of a UnitInterval block
+type Beta struct {
+	Alpha, Beta float64
+}
+
+// LogDensity returns the log prior density
+func (d Beta) LogDensity(x []ad.Scalar) ad.Scalar {
+	lgammaAlpha, _ := math.Lgamma(d.Alpha)
+	lgammaBeta, _ := math.Lgamma(d.Beta)
+	lgammaSum, _ := math.Lgamma(d.Alpha + d.Beta)
+	constant := lgammaSum - lgammaAlpha - lgammaBeta
+	return sum(x, func(xi ad.Scalar) ad.Scalar {
+		logDensity := ads.Add(
+			ads.Mul(ad.NewReal(d.Alpha-1), ads.Log(xi)),
+			ads.Mul(ad.NewReal(d.Beta-1), ads.Log(ads.Sub(ad.NewReal(1), xi))),
+		)
+		return ads.Add(ad.NewReal(constant), logDensity)
+	})
+}
+
+// LKJ is the LKJ prior with shape Eta on a correlation matrix, given by its Cholesky
+// factor from a CholeskyCorr block. Eta = 1 is uniform over correlation matrices.
+// The density is up to its normalising constant.
+type LKJ struct {
+	Eta float64
+}
+
+// LogDensity returns the log prior density up to a constant
+func (d LKJ) LogDensity(L []ad.Scalar) ad.Scalar {
+	K := int(math.Round(math.Sqrt(float64(len(L)))))
+	logDensity := ad.NewScalar(ad.RealType, 0)
+	// the density of the Cholesky factor is prod_i L_ii^(K - i + 2 eta - 2) for i = 2..K
+	for i := 1; i != K; i++ {
+		exponent := float64(K-i-1) + 2*d.Eta - 2
+		logDensity = ads.Add(logDensity, ads.Mul(ad.NewReal(exponent), ads.Log(L[i*K+i])))
+	}
+	return logDensity
+}
+
+func normalLogDensity(x, mu, sigma ad.Scalar) ad.Scalar {
+	z := ads.Div(ads.Sub(x, mu), sigma)
+	logDensity := ads.Mul(ad.NewReal(-0.5), ads.Mul(z, z))
+	return ads.Sub(logDensity, ads.Add(ads.Log(sigma), ad.NewReal(0.5*math.Log(2*math.Pi))))
+}
+
+// sum adds f over the values of a block
+func sum(x []ad.Scalar, f func(ad.Scalar) ad.Scalar) ad.Scalar {
+	total := ad.NewScalar(ad.RealType, 0)
+	for _, xi := range x {
+		total = ads.Add(total, f(xi))
+	}
+	return total
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/experiments"
+)
+
+func plotCommand(args []string) error {
+	flags := newFlagSet("plot", "-manifest <run>.json [flags]",
+		"Render pairwise scatters, marginal histograms, density contours, running moments,\ntraces and autocorrelations of a run.")
+	manifestPath := flags.String("manifest", "", "Manifest of the run to plot.")
+	out := flags.String("out", "plots", "Directory of the plots.")
+	warmup := flags.Float64("warmup", 0.1, "Fraction of draws of every particle to drop as warmup.")
+	weighting := flags.String("weighting", "ess", "Weights of the particles in the pooled running moments: ess, mcse or equal.")
+	params := flags.String("params", "", "Parameters to plot by name, such as \"beta sigma\" or \"beta[2]\", default all.")
+	if err := parseFlags(flags, args, nil); err != nil {
+		return err
+	}
+	if *manifestPath == "" {
+		return fmt.Errorf("plot: no manifest")
+	}
+	run, err := experiments.LoadRun(*manifestPath, *warmup)
+	if err != nil {
+		return err
+	}
+	if run.Weighting, err = experiments.GetWeighting(*weighting); err != nil {
+		return err
+	}
+	if *params != "" {
+		if err := run.Select(splitList(*params)); err != nil {
+			return err
+		}
+		if len(run.Selected()) == 0 {
+			return fmt.Errorf("plot: no parameters in %q", *params)
+		}
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return err
+	}
+	return run.PlotAll(*out)
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+	"github.com/kim-hyunsu/BrownianMonteCarlo/experiments"
+	ad "github.com/pbenner/autodiff"
+)
+
+// sampleConfig holds the flags of sample
+type sampleConfig struct {
+	NumParticles int
+	NumSamples   int
+	NumSteps     int
+	StepSize     float64
+	Collision    string
+	MCMC         string
+	Integrator   string
+	Radius       float64
+	Mass         float64
+	MassSchedule string
+	Seed         int64
+	Dist         string
+	Dim          int
+	Names        string
+	Generate     string
+	Delta        float64
+	Friction     float64
+	FiniteDiff   float64
+	Async        bool
+	Workers      string
+	Out          string
+	Verbose      bool
+	Animate      string
+	SBC          int
+	Thin         int
+}
+
+// bindSampleFlags defines the flags of sample on flags
+func bindSampleFlags(flags *flag.FlagSet, config *sampleConfig) {
+	flags.IntVar(&config.NumParticles, "numParticles", runtime.NumCPU(), "Number of particles.")
+	flags.IntVar(&config.NumSamples, "numSamples", 1000, "Number of samples over all particles.")
+	flags.IntVar(&config.NumSteps, "numSteps", 10, "Number of steps (L).")
+	flags.Float64Var(&config.StepSize, "stepSize", 0., "Size of a step (epsilon), 0 adapts the step size.")
+	flags.StringVar(&config.Collision, "collision", "NormalCollision", "NormalCollision or NoCollision.")
+	flags.StringVar(&config.MCMC, "mcmc", "NUTS", "HMC, NUTS, RMHMC, MALA, RWM, Slice or SGHMC.")
+	flags.StringVar(&config.Integrator, "integrator", "Leapfrog", "Leapfrog, MinimalNorm or Yoshida.")
+	flags.Float64Var(&config.Radius, "radius", 1.0, "Radius of each particle.")
+	flags.Float64Var(&config.Mass, "mass", 1.0, "Mass of the first particle.")
+	flags.StringVar(&config.MassSchedule, "massSchedule", "linear", "Masses of particle i: linear (i+1)*mass, geometric 2^i*mass or constant mass.")
+	flags.Int64Var(&config.Seed, "seed", 0, "Seed of the random number generator, 0 leaves it unseeded.")
+	flags.StringVar(&config.Dist, "dist", "", "Target probability distribution.")
+	flags.IntVar(&config.Dim, "dim", 2, "Dimension of target distribution.")
+	flags.StringVar(&config.Names, "names", "", "Names and shapes of the parameters, such as \"beta[3] sigma\", default X1, X2, ...")
+	flags.StringVar(&config.Generate, "generate", "", "Generated quantities of every draw after warmup: norm, mode (mixtures) or predictive.")
+	flags.Float64Var(&config.Delta, "delta", 1000., "Energy error above which a trajectory of HMC, NUTS or RMHMC diverges.")
+	flags.Float64Var(&config.Friction, "friction", 1., "Friction of SGHMC.")
+	flags.Float64Var(&config.FiniteDiff, "finiteDifferences", 0., "Step of central finite differences for the gradient of the target, 0 uses autodiff.")
+	flags.BoolVar(&config.Async, "async", false, "Step particles asynchronously, colliding against the latest positions of the others.")
+	flags.StringVar(&config.Workers, "workers", "", "Comma separated addresses of worker processes to run the transitions on, see worker.")
+	flags.StringVar(&config.Out, "out", "csv", "Directory of the draws, energies and manifest.")
+	flags.BoolVar(&config.Verbose, "verbose", false, "List all samples.")
+	flags.StringVar(&config.Animate, "animate", "", "Animated GIF (.gif) or directory of PNGs to render the particles of a 2-D run into.")
+	flags.IntVar(&config.SBC, "sbc", 0, "Number of simulation-based calibration replicates (0 disables validation mode).")
+	flags.IntVar(&config.Thin, "thin", 10, "Thinning of draws in validation mode.")
+}
+
+func sampleCommand(args []string) error {
+	flags := newFlagSet("sample", "[flags]",
+		"Sample a target with BMC. Draws, energies and a manifest are written to the output directory.")
+	config := &sampleConfig{}
+	bindSampleFlags(flags, config)
+	if err := parseFlags(flags, args, nil); err != nil {
+		return err
+	}
+	if config.SBC > 0 {
+		if config.FiniteDiff != 0 {
+			return fmt.Errorf("validation mode needs autodiff gradients")
+		}
+		BMC, err := config.newBMC()
+		if err != nil {
+			return err
+		}
+		return validate(BMC, config.Dist, config.SBC, config.NumSamples, config.Thin, config.StepSize)
+	}
+	_, err := runSample(*config)
+	return err
+}
+
+// newBMC configures BMC from the flags
+func (config sampleConfig) newBMC() (bmc.BrownianMonteCarlo, error) {
+	var sampler bmc.MCMC
+	var collide bmc.Collision
+
+	// Integrator
+	integrator := bmc.GetIntegrator(config.Integrator)
+	if integrator == nil {
+		return bmc.BrownianMonteCarlo{}, fmt.Errorf("unknown integrator %q", config.Integrator)
+	}
+
+	// Sampler
+	stepSize := ad.NewScalar(ad.RealType, config.StepSize)
+	switch config.MCMC {
+	case "NUTS":
+		sampler = bmc.NUTS{
+			StepSize:   stepSize,
+			Delta:      config.Delta,
+			Integrator: integrator,
+		}
+	case "HMC":
+		sampler = bmc.HMC{
+			StepSize:   stepSize,
+			NumSteps:   config.NumSteps,
+			Delta:      config.Delta,
+			Integrator: integrator,
+		}
+	case "RMHMC":
+		if config.FiniteDiff != 0 {
+			return bmc.BrownianMonteCarlo{}, fmt.Errorf("RMHMC needs second derivatives, which finite differences do not provide")
+		}
+		sampler = bmc.RMHMC{
+			StepSize: stepSize,
+			NumSteps: config.NumSteps,
+			Delta:    config.Delta,
+		}
+	case "MALA":
+		sampler = bmc.MALA{
+			StepSize: stepSize,
+		}
+	case "RWM":
+		sampler = bmc.NewRWM(stepSize, config.NumSamples/10)
+	case "Slice":
+		sampler = bmc.Slice{
+			Width: config.StepSize,
+		}
+	case "SGHMC":
+		if config.StepSize == 0. {
+			return bmc.BrownianMonteCarlo{}, fmt.Errorf("SGHMC needs a fixed step size")
+		}
+		sampler = bmc.SGHMC{
+			StepSize: stepSize,
+			NumSteps: config.NumSteps,
+			Friction: config.Friction,
+		}
+	default:
+		return bmc.BrownianMonteCarlo{}, fmt.Errorf("unknown sampler %q", config.MCMC)
+	}
+
+	// Collide
+	switch config.Collision {
+	case "NormalCollision":
+		collide = bmc.NormalCollision
+	default:
+		collide = bmc.NoCollision
+	}
+	masses := make([]ad.Scalar, config.NumParticles)
+	radii := make([]float64, config.NumParticles)
+	for i := 0; i != config.NumParticles; i++ {
+		switch config.MassSchedule {
+		case "linear":
+			masses[i] = ad.NewScalar(ad.RealType, config.Mass*float64(i+1))
+		case "geometric":
+			masses[i] = ad.NewScalar(ad.RealType, config.Mass*math.Pow(2, float64(i)))
+		case "constant":
+			masses[i] = ad.NewScalar(ad.RealType, config.Mass)
+		default:
+			return bmc.BrownianMonteCarlo{}, fmt.Errorf("unknown mass schedule %q", config.MassSchedule)
+		}
+		radii[i] = config.Radius
+	}
+
+	var workers []string
+	if config.Workers != "" {
+		workers = strings.Split(config.Workers, ",")
+	}
+	var names []string
+	if config.Names != "" {
+		var err error
+		if names, err = bmc.ExpandNames(splitList(config.Names)...); err != nil {
+			return bmc.BrownianMonteCarlo{}, err
+		}
+	}
+
+	var generate bmc.GeneratedQuantities
+	var generatedNames []string
+	if config.Generate != "" {
+		var err error
+		generate, generatedNames, err = experiments.GetGeneratedQuantities(splitList(config.Generate), config.Dist, config.Dim)
+		if err != nil {
+			return bmc.BrownianMonteCarlo{}, err
+		}
+	}
+
+	// adaptive step size
+	maxAdapt := 0
+	if config.StepSize == 0. {
+		maxAdapt = config.NumSamples / 100
+	}
+	return bmc.BrownianMonteCarlo{
+		Sampler:      sampler,
+		Collide:      collide,
+		NumParticles: config.NumParticles,
+		Radius:       radii,
+		Masses:       masses,
+		MaxAdapt:     maxAdapt,
+		Record:       config.Animate != "",
+		Asynchronous: config.Async,
+		Workers:      workers,
+		LogDensity:   config.FiniteDiff != 0,
+		Names:        names,
+
+		GeneratedQuantities: generate,
+		GeneratedNames:      generatedNames,
+	}, nil
+}
+
+// target returns the target to sample, a log density with gradients
+// by finite differences if they are enabled
+func (config sampleConfig) target() (experiments.Distribution, error) {
+	target := experiments.GetDistribution(config.Dist)
+	if target == nil {
+		return nil, fmt.Errorf("unknown distribution %q", config.Dist)
+	}
+	if config.FiniteDiff == 0 {
+		return target, nil
+	}
+	logDensity := func(x []float64) float64 {
+		return math.Log(target(ad.NewVector(ad.RealType, x)).GetValue())
+	}
+	return bmc.FiniteDifferences(logDensity, config.FiniteDiff), nil
+}
+
+// runSample samples, saves the run and returns the path of its manifest.
+// If sampling fails after it started, the draws so far are saved and the error is returned.
+func runSample(config sampleConfig) (string, error) {
+	BMC, err := config.newBMC()
+	if err != nil {
+		return "", err
+	}
+	target, err := config.target()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(config.Out, 0755); err != nil {
+		return "", err
+	}
+	if config.Seed != 0 {
+		rand.Seed(config.Seed)
+	}
+	sample := make(chan bmc.Sample)
+	collidedSample := make(chan bmc.Sample, config.NumSamples)
+	initialX := make([]float64, config.Dim)
+	begin := time.Now()
+	if err := BMC.Sample(target, ad.NewVector(ad.RealType, initialX), sample, collidedSample); err != nil {
+		return "", err
+	}
+	mixture, isMixture := experiments.GetMixture(config.Dist)
+	var tracker *experiments.ModeTracker
+	if isMixture {
+		tracker = experiments.NewModeTracker(mixture, config.NumParticles)
+	}
+	samples := make([]bmc.Sample, 0)
+	for i := 0; i != config.NumSamples; i++ {
+		s, ok := <-sample
+		if !ok {
+			// keep what was sampled before the failure
+			break
+		}
+		if config.Verbose {
+			fmt.Println(i, s)
+		}
+		samples = append(samples, s)
+		if tracker != nil {
+			tracker.Add(s)
+		}
+	}
+	elapsed := time.Since(begin)
+	fmt.Println("[", elapsed, "]")
+	BMC.Stop()
+	if tracker != nil {
+		printModes(os.Stdout, tracker.Occupancy())
+	}
+	if config.Animate != "" {
+		animation := experiments.Animation{Frames: BMC.Frames, Target: experiments.GetDistribution(config.Dist)}
+		if err := animation.Save(config.Animate); err != nil {
+			return "", err
+		}
+	}
+
+	filename := experiments.GetNameFromBMC(BMC, config.Collision, config.Dist, len(samples))
+	path := filepath.Join(config.Out, filename+".csv")
+	if err := experiments.ToCSV(path, samples, BMC); err != nil {
+		return "", err
+	}
+	energyPath := filepath.Join(config.Out, filename+"_energy.csv")
+	if err := experiments.EnergiesToCSV(energyPath, BMC); err != nil {
+		return "", err
+	}
+	// the first tenth of the run is warmup, as in the plots
+	numIterations := len(samples) / config.NumParticles
+	energy := experiments.NewEnergyDiagnostics(BMC.Energies, numIterations/10, 20)
+	manifest := experiments.NewManifest(BMC, config.Collision, config.Dist, len(samples), elapsed)
+	manifest.Samples = path
+	manifest.Dim = config.Dim
+	manifest.NumGenerated = experiments.NumGenerated(samples)
+	manifest.Energies = energyPath
+	manifest.SetEnergyDiagnostics(energy)
+	if err := BMC.Err(); err != nil {
+		manifest.Error = err.Error()
+	}
+	manifestPath := filepath.Join(config.Out, filename+".json")
+	if err := manifest.Save(manifestPath); err != nil {
+		return "", err
+	}
+	return manifestPath, BMC.Err()
+}
+
+// validate checks marginal calibration of every particle by simulation-based calibration
+func validate(BMC bmc.BrownianMonteCarlo, dist string, numReplicates, numDraws, thin int, stepSize float64) error {
+	target, ok := experiments.GetCalibrationTarget(dist)
+	if !ok {
+		return fmt.Errorf("unknown calibration target %q", dist)
+	}
+	if stepSize == 0. {
+		return fmt.Errorf("validation mode needs a fixed step size")
+	}
+	numBins := 20
+	if numDraws+1 < numBins {
+		numBins = numDraws + 1
+	}
+	sbc, err := experiments.SimulationBasedCalibration(BMC, target, numReplicates, numDraws, thin, numBins)
+	if err != nil {
+		return err
+	}
+	statistics, pValues := sbc.ChiSquare()
+	for i := range statistics {
+		for d := range statistics[i] {
+			fmt.Printf("particle %d dim %d: chi2 = %.2f, p = %.4f, ranks %v\n",
+				i, d, statistics[i][d], pValues[i][d], sbc.Histograms[i][d])
+		}
+	}
+	return nil
+}
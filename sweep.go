@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/experiments"
+)
+
+// parameter is a sample flag with the values a sweep takes.
+// In a random search a value low:high is drawn uniformly from the interval.
+type parameter struct {
+	name   string
+	values []string
+}
+
+// job is one run of a sweep
+type job struct {
+	name   string
+	config sampleConfig
+}
+
+func sweepCommand(args []string) error {
+	flags := newFlagSet("sweep", "-grid <grid> [sample flags]",
+		"Sample every combination of a parameter grid, or random combinations with -random.\n"+
+			"Flags of sample set the parameters that do not vary. A grid is given as\n"+
+			"-grid \"radius=1,2.5 numParticles=3,6\" or in the config file as\n"+
+			"\"grid\": {\"radius\": [1, 2.5], \"numParticles\": [3, 6]}. A random search also takes\n"+
+			"intervals such as radius=0.5:3. Every run is a separate process writing to its own\n"+
+			"directory in -out, runs with a manifest are skipped, and a summary of all runs is\n"+
+			"written to summary.csv in -out.")
+	base := &sampleConfig{}
+	bindSampleFlags(flags, base)
+	flags.Lookup("out").DefValue = "sweep"
+	base.Out = "sweep"
+	gridFlag := flags.String("grid", "", "Values per sample flag, name=v1,v2,... separated by spaces or semicolons.")
+	random := flags.Int("random", 0, "Number of random combinations, 0 runs the full grid.")
+	searchSeed := flags.Int64("searchSeed", 1, "Seed of the random search.")
+	jobs := flags.Int("jobs", 1, "Number of runs at the same time.")
+	warmup := flags.Float64("warmup", 0.1, "Fraction of draws of every particle to drop as warmup in the summary.")
+	maxKL := flags.Int("maxKL", 2000, "Maximum number of draws for the KL divergence in the summary.")
+	var configGrid map[string][]json.RawMessage
+	if err := parseFlags(flags, args, map[string]interface{}{"grid": &configGrid}); err != nil {
+		return err
+	}
+	grid, err := parseGrid(*gridFlag, configGrid)
+	if err != nil {
+		return err
+	}
+	for _, p := range grid {
+		if p.name == "out" || !isSampleFlag(p.name) {
+			return fmt.Errorf("sweep: unknown parameter %q", p.name)
+		}
+	}
+	var configs []sampleConfig
+	if *random > 0 {
+		configs, err = randomSearch(*base, grid, *random, rand.New(rand.NewSource(*searchSeed)))
+	} else {
+		configs, err = expandGrid(*base, grid)
+	}
+	if err != nil {
+		return err
+	}
+	if *jobs < 1 {
+		return fmt.Errorf("sweep: -jobs must be at least 1")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	// a random search over discrete values can draw a configuration twice,
+	// its runs would share one directory
+	runs := make([]job, 0, len(configs))
+	scheduled := make(map[string]bool)
+	for _, config := range configs {
+		r := job{name: jobName(config, grid), config: config}
+		if scheduled[r.name] {
+			fmt.Printf("%s: drawn again, skipped\n", r.name)
+			continue
+		}
+		scheduled[r.name] = true
+		r.config.Out = filepath.Join(base.Out, r.name)
+		runs = append(runs, r)
+	}
+	errs := make([]error, len(runs))
+	semaphore := make(chan struct{}, *jobs)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for i := range runs {
+		if manifestOf(runs[i].config.Out) != "" {
+			fmt.Printf("[%d/%d] %s: complete, skipped\n", i+1, len(runs), runs[i].name)
+			continue
+		}
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			errs[i] = runJob(executable, runs[i])
+			mutex.Lock()
+			defer mutex.Unlock()
+			if errs[i] != nil {
+				fmt.Printf("[%d/%d] %s: %v\n", i+1, len(runs), runs[i].name, errs[i])
+			} else {
+				fmt.Printf("[%d/%d] %s: done\n", i+1, len(runs), runs[i].name)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	summaries := make([]experiments.Summary, 0, len(runs))
+	parameters := make([][]string, 0, len(runs))
+	for _, r := range runs {
+		manifestPath := manifestOf(r.config.Out)
+		if manifestPath == "" {
+			continue
+		}
+		run, err := experiments.LoadRun(manifestPath, *warmup)
+		if err != nil {
+			return err
+		}
+		summaries = append(summaries, experiments.Summarize(run, *maxKL))
+		parameters = append(parameters, parameterValues(r.config, grid))
+	}
+	if err := writeSummary(filepath.Join(base.Out, "summary.csv"), grid, parameters, summaries); err != nil {
+		return err
+	}
+	printSummary(os.Stdout, grid, parameters, summaries)
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("sweep: %d of %d runs failed", failed, len(runs))
+	}
+	return nil
+}
+
+// runJob runs sample in a separate process, its output goes to log.txt in the directory of the run
+func runJob(executable string, r job) error {
+	if err := os.MkdirAll(r.config.Out, 0755); err != nil {
+		return err
+	}
+	log, err := os.Create(filepath.Join(r.config.Out, "log.txt"))
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+	cmd := exec.Command(executable, append([]string{"sample"}, sampleArgs(r.config)...)...)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	return cmd.Run()
+}
+
+// manifestOf returns the manifest in the directory of a run, empty if the run is not complete.
+// A run that failed partway leaves a manifest with an error, so that it is sampled again.
+func manifestOf(dir string) string {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, path := range matches {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var manifest experiments.Manifest
+		if err := json.Unmarshal(data, &manifest); err == nil && manifest.Error == "" {
+			return path
+		}
+	}
+	return ""
+}
+
+// sampleFlags binds the flags of sample to a copy of config
+func sampleFlags(config sampleConfig) (*flag.FlagSet, *sampleConfig) {
+	flags := flag.NewFlagSet("sample", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	point := &sampleConfig{}
+	bindSampleFlags(flags, point)
+	*point = config
+	return flags, point
+}
+
+func isSampleFlag(name string) bool {
+	flags, _ := sampleFlags(sampleConfig{})
+	return flags.Lookup(name) != nil
+}
+
+// sampleArgs formats a configuration as the flags of sample
+func sampleArgs(config sampleConfig) []string {
+	flags, _ := sampleFlags(config)
+	args := make([]string, 0)
+	flags.VisitAll(func(f *flag.Flag) {
+		args = append(args, "-"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
+
+// parameterValues lists the values of the grid parameters of a configuration
+func parameterValues(config sampleConfig, grid []parameter) []string {
+	flags, _ := sampleFlags(config)
+	values := make([]string, len(grid))
+	for i, p := range grid {
+		values[i] = flags.Lookup(p.name).Value.String()
+	}
+	return values
+}
+
+// jobName names a run by the values of the grid parameters
+func jobName(config sampleConfig, grid []parameter) string {
+	values := parameterValues(config, grid)
+	fields := make([]string, len(grid))
+	for i, p := range grid {
+		fields[i] = p.name + "-" + strings.NewReplacer("/", "_", " ", "_").Replace(values[i])
+	}
+	if len(fields) == 0 {
+		return "base"
+	}
+	return strings.Join(fields, "_")
+}
+
+// parseGrid merges the grid of the flag with the grid of the config file, the flag takes precedence
+func parseGrid(gridFlag string, configGrid map[string][]json.RawMessage) ([]parameter, error) {
+	values := make(map[string][]string)
+	for name, raws := range configGrid {
+		for _, raw := range raws {
+			value, err := configValue(raw)
+			if err != nil {
+				return nil, fmt.Errorf("grid: %s: %v", name, err)
+			}
+			values[name] = append(values[name], value)
+		}
+	}
+	for _, field := range strings.FieldsFunc(gridFlag, func(r rune) bool { return r == ' ' || r == ';' }) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("grid: %q is not name=v1,v2,...", field)
+		}
+		values[parts[0]] = strings.Split(parts[1], ",")
+	}
+	grid := make([]parameter, 0, len(values))
+	for name, vs := range values {
+		if len(vs) == 0 {
+			return nil, fmt.Errorf("grid: no values for %s", name)
+		}
+		grid = append(grid, parameter{name: name, values: vs})
+	}
+	sort.Slice(grid, func(i, j int) bool { return grid[i].name < grid[j].name })
+	return grid, nil
+}
+
+// expandGrid returns the configuration of every combination of the grid
+func expandGrid(base sampleConfig, grid []parameter) ([]sampleConfig, error) {
+	configs := []sampleConfig{base}
+	for _, p := range grid {
+		expanded := make([]sampleConfig, 0, len(configs)*len(p.values))
+		for _, config := range configs {
+			for _, value := range p.values {
+				if strings.Contains(value, ":") {
+					return nil, fmt.Errorf("grid: interval %s=%s needs -random", p.name, value)
+				}
+				flags, point := sampleFlags(config)
+				if err := flags.Set(p.name, value); err != nil {
+					return nil, fmt.Errorf("grid: %s=%s: %v", p.name, value, err)
+				}
+				expanded = append(expanded, *point)
+			}
+		}
+		configs = expanded
+	}
+	return configs, nil
+}
+
+// randomSearch draws n configurations, every parameter takes one of its values
+// or a uniform draw from its interval, an integer one if the flag is an integer
+func randomSearch(base sampleConfig, grid []parameter, n int, rng *rand.Rand) ([]sampleConfig, error) {
+	configs := make([]sampleConfig, n)
+	for i := range configs {
+		flags, point := sampleFlags(base)
+		for _, p := range grid {
+			value := p.values[rng.Intn(len(p.values))]
+			if bounds := strings.SplitN(value, ":", 2); len(bounds) == 2 {
+				var err error
+				if value, err = drawFromInterval(flags.Lookup(p.name), bounds[0], bounds[1], rng); err != nil {
+					return nil, fmt.Errorf("grid: %s=%s: %v", p.name, strings.Join(bounds, ":"), err)
+				}
+			}
+			if err := flags.Set(p.name, value); err != nil {
+				return nil, fmt.Errorf("grid: %s=%s: %v", p.name, value, err)
+			}
+		}
+		configs[i] = *point
+	}
+	return configs, nil
+}
+
+// drawFromInterval draws a value of f uniformly from [low, high], both included for integer flags
+func drawFromInterval(f *flag.Flag, low, high string, rng *rand.Rand) (string, error) {
+	if f == nil {
+		return "", fmt.Errorf("no such flag")
+	}
+	if getter, ok := f.Value.(flag.Getter); ok {
+		switch getter.Get().(type) {
+		case int, int64:
+			l, err := strconv.ParseInt(low, 10, 64)
+			if err != nil {
+				return "", err
+			}
+			h, err := strconv.ParseInt(high, 10, 64)
+			if err != nil {
+				return "", err
+			}
+			if h < l {
+				return "", fmt.Errorf("empty interval")
+			}
+			return strconv.FormatInt(l+rng.Int63n(h-l+1), 10), nil
+		}
+	}
+	l, err := strconv.ParseFloat(low, 64)
+	if err != nil {
+		return "", err
+	}
+	h, err := strconv.ParseFloat(high, 64)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(l+(h-l)*rng.Float64(), 'g', -1, 64), nil
+}
+
+// summaryRow formats the statistics of a run for the summary
+func summaryRow(s experiments.Summary) []string {
+	return []string{
+		s.Name,
+		fmt.Sprint(s.NumDraws),
+		fmt.Sprintf("%.4f", s.MaxRHat()),
+		fmt.Sprintf("%.1f", s.MinESS()),
+		fmt.Sprintf("%.4f", s.KL),
+		fmt.Sprintf("%.4f", s.ModeTV),
+		fmt.Sprintf("%.4f", s.MeanAcceptanceRate),
+		fmt.Sprint(s.NumDivergences),
+		fmt.Sprintf("%.4f", s.MeanEBFMI),
+		fmt.Sprintf("%.2f", s.EvaluationsPerDraw),
+		fmt.Sprintf("%.2f", s.Elapsed),
+	}
+}
+
+var summaryHeader = []string{"run", "draws", "maxRHat", "minESS", "KL", "modeTV", "acceptance", "divergences", "EBFMI", "evaluationsPerDraw", "seconds"}
+
+// writeSummary writes one row per run with the grid parameters and the statistics of the run
+func writeSummary(path string, grid []parameter, parameters [][]string, summaries []experiments.Summary) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	wr := csv.NewWriter(file)
+	header := make([]string, 0, len(grid)+len(summaryHeader))
+	for _, p := range grid {
+		header = append(header, p.name)
+	}
+	if err := wr.Write(append(header, summaryHeader...)); err != nil {
+		return err
+	}
+	for i, s := range summaries {
+		if err := wr.Write(append(append([]string{}, parameters[i]...), summaryRow(s)...)); err != nil {
+			return err
+		}
+	}
+	wr.Flush()
+	if err := wr.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// printSummary prints the summary as a table
+func printSummary(out io.Writer, grid []parameter, parameters [][]string, summaries []experiments.Summary) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, p := range grid {
+		fmt.Fprint(w, p.name, "\t")
+	}
+	fmt.Fprintln(w, strings.Join(summaryHeader[1:], "\t"))
+	for i, s := range summaries {
+		for _, value := range parameters[i] {
+			fmt.Fprint(w, value, "\t")
+		}
+		fmt.Fprintln(w, strings.Join(summaryRow(s)[1:], "\t"))
+	}
+	w.Flush()
+}
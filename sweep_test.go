@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestParseGrid(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		gridFlag   string
+		configGrid map[string][]json.RawMessage
+		want       []parameter
+		fails      bool
+	}{
+		{
+			name:     "flag",
+			gridFlag: "stepSize=0.1,0.2 numParticles=2;mcmc=HMC",
+			want: []parameter{
+				{name: "mcmc", values: []string{"HMC"}},
+				{name: "numParticles", values: []string{"2"}},
+				{name: "stepSize", values: []string{"0.1", "0.2"}},
+			},
+		},
+		{
+			name:     "flag overrides config",
+			gridFlag: "numParticles=2",
+			configGrid: map[string][]json.RawMessage{
+				"numParticles": {json.RawMessage(`8`)},
+				"mcmc":         {json.RawMessage(`"NUTS"`), json.RawMessage(`"RWM"`)},
+			},
+			want: []parameter{
+				{name: "mcmc", values: []string{"NUTS", "RWM"}},
+				{name: "numParticles", values: []string{"2"}},
+			},
+		},
+		{name: "empty", want: []parameter{}},
+		{name: "no values", gridFlag: "numParticles=", fails: true},
+		{name: "no name", gridFlag: "numParticles", fails: true},
+		{name: "object", configGrid: map[string][]json.RawMessage{"seed": {json.RawMessage(`{}`)}}, fails: true},
+	} {
+		grid, err := parseGrid(c.gridFlag, c.configGrid)
+		if c.fails {
+			if err == nil {
+				t.Errorf("%s: no error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		} else if !reflect.DeepEqual(grid, c.want) {
+			t.Errorf("%s: grid %v, want %v", c.name, grid, c.want)
+		}
+	}
+}
+
+func TestExpandGrid(t *testing.T) {
+	base := sampleConfig{NumParticles: 4, MCMC: "NUTS", StepSize: 0.1}
+	for _, c := range []struct {
+		name  string
+		grid  []parameter
+		want  int
+		fails bool
+	}{
+		{name: "empty", want: 1},
+		{name: "product", grid: []parameter{{"mcmc", []string{"HMC", "NUTS"}}, {"numParticles", []string{"2", "4", "8"}}}, want: 6},
+		{name: "interval", grid: []parameter{{"stepSize", []string{"0.1:0.2"}}}, fails: true},
+		{name: "unknown flag", grid: []parameter{{"particles", []string{"2"}}}, fails: true},
+		{name: "not an integer", grid: []parameter{{"numParticles", []string{"2.5"}}}, fails: true},
+	} {
+		configs, err := expandGrid(base, c.grid)
+		if c.fails {
+			if err == nil {
+				t.Errorf("%s: no error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if len(configs) != c.want {
+			t.Errorf("%s: %d configurations, want %d", c.name, len(configs), c.want)
+		}
+		seen := make(map[string]bool)
+		for _, config := range configs {
+			if config.StepSize != base.StepSize {
+				t.Errorf("%s: step size %v of the base changed to %v", c.name, base.StepSize, config.StepSize)
+			}
+			seen[jobName(config, c.grid)] = true
+		}
+		if len(seen) != len(configs) {
+			t.Errorf("%s: %d distinct configurations of %d", c.name, len(seen), len(configs))
+		}
+	}
+}
+
+func TestRandomSearch(t *testing.T) {
+	grid := []parameter{
+		{"mcmc", []string{"HMC", "NUTS"}},
+		{"numParticles", []string{"2:8"}},
+		{"seed", []string{"1:100000"}},
+		{"stepSize", []string{"0.05:0.2"}},
+	}
+	configs, err := randomSearch(sampleConfig{}, grid, 200, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	particles := make(map[int]bool)
+	for _, config := range configs {
+		if config.MCMC != "HMC" && config.MCMC != "NUTS" {
+			t.Errorf("mcmc %q", config.MCMC)
+		}
+		if config.NumParticles < 2 || config.NumParticles > 8 {
+			t.Errorf("numParticles %d outside 2:8", config.NumParticles)
+		}
+		particles[config.NumParticles] = true
+		if config.Seed < 1 || config.Seed > 100000 {
+			t.Errorf("seed %d outside 1:100000", config.Seed)
+		}
+		if config.StepSize < 0.05 || config.StepSize > 0.2 {
+			t.Errorf("stepSize %v outside 0.05:0.2", config.StepSize)
+		}
+		// the draw is not rounded, so it formats back to itself
+		if s := strconv.FormatFloat(config.StepSize, 'g', -1, 64); len(s) <= 6 {
+			t.Errorf("stepSize %s is rounded", s)
+		}
+	}
+	if len(particles) != 7 {
+		t.Errorf("numParticles takes %d of the 7 values of 2:8", len(particles))
+	}
+
+	for _, value := range []string{"2.5:8", "8:2", "a:b"} {
+		if _, err := randomSearch(sampleConfig{}, []parameter{{"numParticles", []string{value}}}, 1, rand.New(rand.NewSource(1))); err == nil {
+			t.Errorf("numParticles=%s: no error", value)
+		}
+	}
+}
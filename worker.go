@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+)
+
+func workerCommand(args []string) error {
+	flags := newFlagSet("worker", "-listen <address> [sample flags]",
+		"Serve the transitions of particles to a sample run with -workers. The coordinator keeps\n"+
+			"positions, collisions and statistics, so the worker only needs the target and the sampler:\n"+
+			"-dist and -friction must match those of the run, which checks -mcmc, -integrator, -numSteps,\n"+
+			"-delta and -finiteDifferences when it connects and sends its step size with every transition.\n"+
+			"RWM adapts its covariance separately on every worker, from the transitions it runs.\n"+
+			"For example, on one machine:\n"+
+			"  bmc worker -listen localhost:7001 -dist AsymMOG2d &\n"+
+			"  bmc worker -listen localhost:7002 -dist AsymMOG2d &\n"+
+			"  bmc sample -workers localhost:7001,localhost:7002 -dist AsymMOG2d")
+	config := &sampleConfig{}
+	bindSampleFlags(flags, config)
+	listen := flags.String("listen", "localhost:7000", "Address to listen on.")
+	if err := parseFlags(flags, args, nil); err != nil {
+		return err
+	}
+	BMC, err := config.newBMC()
+	if err != nil {
+		return err
+	}
+	target, err := config.target()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	fmt.Println("worker listening on", listener.Addr())
+	bmc.ServeWorker(listener, bmc.NewWorker(target, BMC))
+	return nil
+}
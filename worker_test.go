@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kim-hyunsu/BrownianMonteCarlo/bmc"
+	"github.com/kim-hyunsu/BrownianMonteCarlo/experiments"
+)
+
+// startWorker starts a bmc worker process on a free port and returns it with its address
+func startWorker(t *testing.T, binary string, args ...string) (*exec.Cmd, string) {
+	cmd := exec.Command(binary, append([]string{"worker", "-listen", "127.0.0.1:0"}, args...)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		cmd.Process.Kill()
+		t.Fatalf("worker did not start: %v", err)
+	}
+	return cmd, strings.TrimSpace(strings.TrimPrefix(line, "worker listening on"))
+}
+
+func TestWorkerProcesses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds bmc and starts worker processes")
+	}
+	dir, err := ioutil.TempDir("", "bmc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	binary := filepath.Join(dir, "bmc")
+	if out, err := exec.Command("go", "build", "-o", binary, ".").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	// the workers are started with a step size that would reject every transition,
+	// the run sends its own
+	samplerArgs := []string{"-dist", "AsymMOG2d", "-mcmc", "HMC", "-numSteps", "10"}
+	addresses := make([]string, 2)
+	for i := range addresses {
+		worker, address := startWorker(t, binary, append(samplerArgs, "-stepSize", "100")...)
+		defer worker.Process.Kill()
+		addresses[i] = address
+	}
+	flags := flag.NewFlagSet("sample", flag.ContinueOnError)
+	config := &sampleConfig{}
+	bindSampleFlags(flags, config)
+	if err := flags.Parse(append(samplerArgs, "-stepSize", "0.2", "-numParticles", "2", "-numSamples", "400",
+		"-seed", "1", "-out", dir, "-workers", strings.Join(addresses, ","))); err != nil {
+		t.Fatal(err)
+	}
+	manifestPath, err := runSample(*config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err := experiments.LoadRun(manifestPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, rate := range run.Manifest.AcceptanceRate {
+		if rate < 0.5 {
+			t.Errorf("particle %d: acceptance rate %v with step size 0.2", i, rate)
+		}
+		if run.Manifest.NumEvaluations[i] == 0 {
+			t.Errorf("particle %d: no evaluations counted on its worker", i)
+		}
+	}
+
+	worker, address := startWorker(t, binary, "-dist", "AsymMOG2d", "-mcmc", "HMC", "-numSteps", "5")
+	defer worker.Process.Kill()
+	config.Workers = address
+	var workerError *bmc.WorkerError
+	if _, err := runSample(*config); !errors.As(err, &workerError) {
+		t.Errorf("worker with another number of steps: got %v, want *bmc.WorkerError", err)
+	}
+}